@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"go--python-executor/internal/auth"
 	"go--python-executor/internal/models"
 	"io"
 	"log"
@@ -13,6 +15,10 @@ import (
 )
 
 func main() {
+	// The API key comes from -api-key or, if not given, the environment
+	apiKey := flag.String("api-key", os.Getenv("PYEXEC_API_KEY"), "API key sent to the server (default $PYEXEC_API_KEY)")
+	flag.Parse()
+
 	// Read Python code from a file
 	code, err := os.ReadFile("../../code.py")
 	if err != nil {
@@ -28,7 +34,15 @@ func main() {
 
 	// Send HTTPS POST request
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	resp, err := http.Post("https://localhost/execute", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, "https://localhost/execute", bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, *apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Fatalf("Failed to send request: %v", err)
 	}
@@ -47,10 +61,12 @@ func main() {
 		log.Fatalf("Failed to parse response JSON: %v", err)
 	}
 
-	// Print response
+	// Print response. Failed requests carry an error object instead of
+	// output.
 	fmt.Println("Server Response:")
-	if response.Error != "" {
-		fmt.Println("Error:", response.Error)
+	if response.Error != nil {
+		fmt.Printf("Error: %s (%s, HTTP %d)\n", response.Error.Message, response.Error.Code, resp.StatusCode)
+		os.Exit(1)
 	}
 	if response.Stderr != "" {
 		fmt.Println("Stderr:", response.Stderr)
@@ -58,4 +74,7 @@ func main() {
 	if response.Stdout != "" {
 		fmt.Println("Stdout:", response.Stdout)
 	}
+	if response.ExitCode != 0 {
+		fmt.Println("Exit code:", response.ExitCode)
+	}
 }
@@ -1,18 +1,141 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
+	"go--python-executor/internal/audit"
+	"go--python-executor/internal/config"
 	"go--python-executor/internal/handler"
-	"log"
+	"go--python-executor/internal/logging"
+	"go--python-executor/internal/tracing"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	// Register the execute handler
-	http.HandleFunc("/execute", handler.ExecuteHandler)
+	// Load the configuration from defaults, file, environment and flags
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if printConfig {
+		cfg.Write(os.Stdout)
+		return
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat); err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		os.Exit(1)
+	}
+	if err := handler.Configure(cfg); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Export spans as configured and continue traces of incoming requests
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.TraceExporter,
+		Endpoint:    cfg.TraceEndpoint,
+		File:        cfg.TraceFile,
+		SampleRatio: cfg.TraceSampleRatio,
+	})
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Record every execution for auditing
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		auditLog, err = audit.Open(audit.Options{
+			Path:       cfg.AuditLog,
+			MaxSize:    cfg.AuditLogMaxSize,
+			MaxBackups: cfg.AuditLogMaxBackups,
+			Code:       cfg.AuditLogCode,
+		})
+		if err != nil {
+			slog.Error("Failed to open audit log", "error", err)
+			os.Exit(1)
+		}
+		handler.SetAuditLog(auditLog)
+	}
+
+	// Reload the configuration on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if _, err := handler.ReloadConfig(); err != nil {
+				slog.Error("Config reload failed", "error", err)
+			}
+		}
+	}()
+
+	// Register the API handlers
+	handler.RegisterRoutes(http.DefaultServeMux)
+
+	// Requests run under a context that is cancelled to abort executions
+	// still running when the shutdown deadline passes
+	requests, abort := context.WithCancel(context.Background())
+	defer abort()
+	server := &http.Server{
+		Addr:        cfg.Listen,
+		Handler:     handler.TraceRequests(handler.LogRequests(handler.RouteErrors(http.DefaultServeMux))),
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
 
 	// Start the server
-	port := ":8080"
-	fmt.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	slog.Info("Server starting", "listen", cfg.Listen)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	// Wait for SIGINT or SIGTERM
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errs:
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	case <-stopped.Done():
+	}
+	// A second signal terminates immediately
+	stop()
+
+	// Stop accepting requests and let running executions finish
+	timeout := time.Duration(cfg.ShutdownTimeout)
+	slog.Info("Shutting down, waiting for running executions", "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Aborting running executions", "error", err)
+		abort()
+		server.Close()
+	}
+
+	if err := handler.Shutdown(); err != nil {
+		slog.Error("Failed to close session manager", "error", err)
+	}
+	if auditLog != nil {
+		handler.SetAuditLog(nil)
+		if err := auditLog.Close(); err != nil {
+			slog.Error("Failed to close audit log", "error", err)
+		}
+	}
+	flush, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flush); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,257 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record describes a single execution in the audit log
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Tenant and Key identify the caller; both are empty when
+	// authentication is disabled
+	Tenant    string `json:"tenant,omitempty"`
+	Key       string `json:"key,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	SessionID string `json:"session_id"`
+	// ReplayedFrom is set for replays and names the session whose history
+	// was re-executed into SessionID; the code is that history
+	ReplayedFrom string `json:"replayed_from,omitempty"`
+	CodeSHA256   string `json:"code_sha256"`
+	// Code is only kept when the log is opened with Options.Code
+	Code       string `json:"code,omitempty"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	CPUMs      int64  `json:"cpu_ms"`
+	WallMs     int64  `json:"wall_ms"`
+}
+
+// Options configures an audit log
+type Options struct {
+	// Path is the file records are appended to
+	Path string
+	// MaxSize rotates the file once it would grow beyond this many bytes.
+	// Zero never rotates.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept as Path.1 (the most
+	// recent) to Path.N. Zero keeps all of them.
+	MaxBackups int
+	// Code records the full code of executions besides its hash
+	Code bool
+}
+
+// Filter selects records of a query. Zero fields match every record.
+type Filter struct {
+	// SessionID matches the executions in a session of Tenant and its
+	// replays into other sessions. Session IDs are only unique within a
+	// tenant, so an empty Tenant then stands for the default tenant.
+	SessionID string
+	Tenant    string
+	// Since and Until bound the time of records; Since is inclusive and
+	// Until exclusive
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of records returned, keeping the newest
+	Limit int
+}
+
+// matches reports whether rec is selected by f
+func (f Filter) matches(rec Record) bool {
+	if f.SessionID != "" && (rec.Tenant != f.Tenant || (rec.SessionID != f.SessionID && rec.ReplayedFrom != f.SessionID)) {
+		return false
+	}
+	return (f.Tenant == "" || rec.Tenant == f.Tenant) &&
+		(f.Since.IsZero() || !rec.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || rec.Time.Before(f.Until))
+}
+
+// Log appends records to a file of JSON lines, rotating it when it grows
+// too large. Records are never changed once written.
+type Log struct {
+	opts  Options
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// Open opens the audit log at opts.Path for appending, creating it if needed
+func Open(opts Options) (*Log, error) {
+	l := &Log{opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current file. Callers must hold l.mutex or have exclusive
+// access to the log.
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// backupPath returns the path of the nth rotated file
+func (l *Log) backupPath(n int) string {
+	return l.opts.Path + "." + strconv.Itoa(n)
+}
+
+// backups returns the number of rotated files
+func (l *Log) backups() int {
+	n := 0
+	for {
+		if _, err := os.Stat(l.backupPath(n + 1)); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// rotate moves the current file to Path.1, shifting older files up and
+// dropping those beyond MaxBackups, and starts a new file. Callers must
+// hold l.mutex.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %v", err)
+	}
+	for n := l.backups(); n >= 1; n-- {
+		if l.opts.MaxBackups > 0 && n >= l.opts.MaxBackups {
+			os.Remove(l.backupPath(n))
+			continue
+		}
+		if err := os.Rename(l.backupPath(n), l.backupPath(n+1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %v", err)
+		}
+	}
+	if err := os.Rename(l.opts.Path, l.backupPath(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %v", err)
+	}
+	return l.open()
+}
+
+// HashCode returns the hex-encoded SHA-256 hash of code as recorded in the
+// log
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Write appends rec to the log. The hash of rec.Code is recorded, and the
+// code itself only if the log keeps code.
+func (l *Log) Write(rec Record) error {
+	rec.CodeSHA256 = HashCode(rec.Code)
+	if !l.opts.Code {
+		rec.Code = ""
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return errors.New("audit log is closed")
+	}
+	if l.opts.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// Query returns the records selected by filter, newest first. Lines that
+// cannot be decoded are skipped.
+func (l *Log) Query(filter Filter) ([]Record, error) {
+	// Open every file before reading so a rotation in the meantime cannot
+	// skip or repeat records
+	l.mutex.Lock()
+	var files []*os.File
+	for n := l.backups(); n >= 0; n-- {
+		path := l.opts.Path
+		if n > 0 {
+			path = l.backupPath(n)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	l.mutex.Unlock()
+
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	// Read oldest first and keep only the newest records within the limit
+	records := []Record{}
+	for _, file := range files {
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var rec Record
+				if json.Unmarshal(line, &rec) == nil && filter.matches(rec) {
+					if filter.Limit > 0 && len(records) == filter.Limit {
+						records = records[1:]
+					}
+					records = append(records, rec)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read audit log: %v", err)
+			}
+		}
+	}
+	slices.Reverse(records)
+	return records, nil
+}
+
+// Close closes the log; later writes fail
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, rec := range []Record{
+		{Tenant: "acme", SessionID: "a", Code: "print(1)", Outcome: "ok"},
+		{Tenant: "acme", SessionID: "b", Code: "print(2)", Outcome: "error"},
+		{Tenant: "globex", SessionID: "a", Code: "print(3)", Outcome: "ok"},
+	} {
+		rec.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := log.Write(rec); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	records, err := log.Query(Filter{Tenant: "acme"})
+	if err != nil || len(records) != 2 || records[0].SessionID != "b" || records[1].SessionID != "a" {
+		t.Fatalf("Expected both records of acme, newest first, got %+v (%v)", records, err)
+	}
+	if records[1].CodeSHA256 != HashCode("print(1)") || records[1].Code != "" {
+		t.Fatalf("Expected only the hash of the code, got %+v", records[1])
+	}
+
+	// Sessions are selected within their tenant
+	records, _ = log.Query(Filter{SessionID: "a", Tenant: "globex"})
+	if len(records) != 1 || records[0].Code != "" || records[0].CodeSHA256 != HashCode("print(3)") {
+		t.Fatalf("Expected the record of session a of globex, got %+v", records)
+	}
+	if records, _ = log.Query(Filter{SessionID: "a"}); len(records) != 0 {
+		t.Fatalf("Expected no record of session a in the default tenant, got %+v", records)
+	}
+	records, _ = log.Query(Filter{SessionID: "a", Tenant: "acme", Since: start.Add(time.Minute)})
+	if len(records) != 0 {
+		t.Fatalf("Expected no later record of session a of acme, got %+v", records)
+	}
+	records, _ = log.Query(Filter{Until: start.Add(time.Minute)})
+	if len(records) != 1 || records[0].Tenant != "acme" {
+		t.Fatalf("Expected the first record, got %+v", records)
+	}
+
+	// The limit keeps the newest records
+	records, _ = log.Query(Filter{Limit: 2})
+	if len(records) != 2 || records[0].Tenant != "globex" || records[1].SessionID != "b" {
+		t.Fatalf("Expected the 2 newest records, got %+v", records)
+	}
+}
+
+func TestRecordCode(t *testing.T) {
+	log, err := Open(Options{Path: filepath.Join(t.TempDir(), "audit.jsonl"), Code: true})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	log.Write(Record{Time: time.Now(), Code: "x = 1"})
+	records, _ := log.Query(Filter{})
+	if len(records) != 1 || records[0].Code != "x = 1" || records[0].CodeSHA256 != HashCode("x = 1") {
+		t.Fatalf("Expected the code and its hash, got %+v", records)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(Options{Path: path, MaxSize: 300, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		rec := Record{Time: start.Add(time.Duration(i) * time.Second), SessionID: strings.Repeat("s", 50), Outcome: "ok"}
+		if err := log.Write(rec); err != nil {
+			t.Fatalf("Failed to write record %d: %v", i+1, err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 300 {
+			t.Fatalf("Expected %s to be rotated at 300 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("Expected backups beyond the limit to be removed")
+	}
+
+	// The kept files are returned newest first, starting with the last record
+	records, err := log.Query(Filter{})
+	if err != nil || len(records) == 0 || len(records) >= 10 {
+		t.Fatalf("Expected the records of the kept files, got %d (%v)", len(records), err)
+	}
+	for i := 1; i < len(records); i++ {
+		if !records[i-1].Time.After(records[i].Time) {
+			t.Fatalf("Expected records newest first, got %v before %v", records[i-1].Time, records[i].Time)
+		}
+	}
+	if first := records[0].Time; !first.Equal(start.Add(9 * time.Second)) {
+		t.Fatalf("Expected the last record first, got %v", first)
+	}
+
+	// Reopening continues the log
+	log.Close()
+	log, err = Open(Options{Path: path, MaxSize: 300, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %v", err)
+	}
+	defer log.Close()
+	log.Write(Record{Time: start.Add(time.Minute), SessionID: "reopened"})
+	records, _ = log.Query(Filter{})
+	if records[0].SessionID != "reopened" {
+		t.Fatalf("Expected the new record first, got %+v", records[0])
+	}
+}
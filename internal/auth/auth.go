@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"go--python-executor/internal/executor"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoCredentials is returned for requests carrying neither an API key nor
+// a bearer token
+var ErrNoCredentials = errors.New("missing API key or bearer token")
+
+// Credential types a principal can be authenticated with
+const (
+	CredentialAPIKey = "key"
+	CredentialJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request together with the
+// limits that apply to it
+type Principal struct {
+	// Type is the kind of credential the caller authenticated with
+	Type string
+	// ID identifies the caller among callers of the same Type
+	ID string
+	// Tenant is the customer the caller belongs to, if known
+	Tenant string
+	// Runtimes lists the runtimes the caller may execute code with; nil
+	// allows every runtime
+	Runtimes []string
+	// MaxTimeout caps the execution timeout; zero leaves the server limit
+	MaxTimeout time.Duration
+	// MemoryLimit caps the memory of an execution in bytes; zero means no
+	// limit beyond the server's
+	MemoryLimit int64
+	// MaxPriority is the highest priority the caller may queue executions
+	// with; empty allows normal and batch
+	MaxPriority string
+}
+
+// Owner returns the name that owns the sessions the caller creates: the ID
+// prefixed with the credential type, so an API key and a JWT subject with
+// the same ID are different callers
+func (p Principal) Owner() string {
+	if p.ID == "" {
+		return ""
+	}
+	return p.Type + ":" + p.ID
+}
+
+// Account returns the name rate limits and quotas are counted against: the
+// tenant if known, the caller otherwise
+func (p Principal) Account() string {
+	if p.Tenant != "" {
+		return "tenant:" + p.Tenant
+	}
+	return p.Owner()
+}
+
+// AllowsPriority reports whether the caller may queue executions with
+// priority
+func (p Principal) AllowsPriority(priority executor.Priority) bool {
+	allowed, err := executor.ParsePriority(p.MaxPriority)
+	return err == nil && priority <= allowed
+}
+
+// AllowsRuntime reports whether the caller may use runtime
+func (p Principal) AllowsRuntime(runtime string) bool {
+	return p.Runtimes == nil || slices.Contains(p.Runtimes, runtime)
+}
+
+// Authenticator accepts API keys and signed JWT bearer tokens. Either may
+// be nil to accept only the other.
+type Authenticator struct {
+	Keys *Keys
+	JWT  *JWTVerifier
+}
+
+// AuthenticateRequest returns the caller of r. A bearer token is verified
+// as a JWT, otherwise the X-API-Key header is checked.
+func (a *Authenticator) AuthenticateRequest(r *http.Request) (Principal, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && a.JWT != nil {
+		return a.JWT.Verify(token)
+	}
+	if a.Keys != nil && r.Header.Get(APIKeyHeader) != "" {
+		return a.Keys.AuthenticateRequest(r)
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the caller of a request
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the caller carried by ctx and whether there is one
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go--python-executor/internal/executor"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned for bearer tokens that fail verification
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// JWTOptions configures the verification of JWT bearer tokens. At least one
+// key file must be given.
+type JWTOptions struct {
+	// HMACKeyFile holds the shared secret of HS256, HS384 and HS512 tokens
+	HMACKeyFile string
+	// RSAPublicKeyFile holds the PEM public key of RS256, RS384 and RS512
+	// tokens
+	RSAPublicKeyFile string
+	// Issuer and Audience are required in tokens when non-empty
+	Issuer   string
+	Audience string
+}
+
+// Claims are the JWT claims understood by the server. Limits left out of a
+// token do not restrict the caller beyond the server configuration.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Tenant is the customer the caller belongs to and is required
+	Tenant string `json:"tenant"`
+	// Runtimes lists the runtimes the caller may execute code with
+	Runtimes []string `json:"runtimes,omitempty"`
+	// MaxTimeout caps the execution timeout in seconds
+	MaxTimeout float64 `json:"max_timeout,omitempty"`
+	// MemoryLimit caps the memory of an execution in bytes
+	MemoryLimit int64 `json:"memory_limit,omitempty"`
+	// MaxPriority is the highest priority the caller may queue executions
+	// with
+	MaxPriority string `json:"max_priority,omitempty"`
+}
+
+// JWTVerifier checks the signature and claims of bearer tokens
+type JWTVerifier struct {
+	hmacKey []byte
+	rsaKey  any
+	parser  *jwt.Parser
+}
+
+// NewJWTVerifier loads the verification keys
+func NewJWTVerifier(opts JWTOptions) (*JWTVerifier, error) {
+	v := &JWTVerifier{}
+	var methods []string
+	if opts.HMACKeyFile != "" {
+		key, err := os.ReadFile(opts.HMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT HMAC key: %v", err)
+		}
+		if len(key) == 0 {
+			return nil, errors.New("JWT HMAC key file is empty")
+		}
+		v.hmacKey = key
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if opts.RSAPublicKeyFile != "" {
+		pem, err := os.ReadFile(opts.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT RSA public key: %v", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT RSA public key: %v", err)
+		}
+		v.rsaKey = key
+		methods = append(methods, "RS256", "RS384", "RS512")
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no JWT verification key configured")
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	v.parser = jwt.NewParser(parserOpts...)
+	return v, nil
+}
+
+// key returns the verification key matching the signing method of token
+func (v *JWTVerifier) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.hmacKey, nil
+	case *jwt.SigningMethodRSA:
+		return v.rsaKey, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// Verify checks token and returns the caller it describes. The caller is
+// identified by the subject, or by the tenant for tokens without one.
+func (v *JWTVerifier) Verify(token string) (Principal, error) {
+	var claims Claims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Tenant == "" {
+		return Principal{}, fmt.Errorf("%w: missing tenant claim", ErrInvalidToken)
+	}
+	if claims.MaxTimeout < 0 || claims.MemoryLimit < 0 {
+		return Principal{}, fmt.Errorf("%w: negative limit", ErrInvalidToken)
+	}
+	if _, err := executor.ParsePriority(claims.MaxPriority); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	id := claims.Subject
+	if id == "" {
+		id = claims.Tenant
+	}
+	return Principal{
+		Type:        CredentialJWT,
+		ID:          id,
+		Tenant:      claims.Tenant,
+		Runtimes:    claims.Runtimes,
+		MaxTimeout:  time.Duration(claims.MaxTimeout * float64(time.Second)),
+		MemoryLimit: claims.MemoryLimit,
+		MaxPriority: claims.MaxPriority,
+	}, nil
+}
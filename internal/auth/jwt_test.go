@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sign returns a token with the given claims signed by key
+func sign(t *testing.T, method jwt.SigningMethod, key any, claims Claims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestJWTVerifier(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("shared-secret")
+	hmacFile := filepath.Join(dir, "hmac.key")
+	os.WriteFile(hmacFile, secret, 0600)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaFile := filepath.Join(dir, "rsa.pem")
+	os.WriteFile(rsaFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	verifier, err := NewJWTVerifier(JWTOptions{HMACKeyFile: hmacFile, RSAPublicKeyFile: rsaFile, Issuer: "portal"})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	valid := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Issuer:    "portal",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Tenant:      "acme",
+		Runtimes:    []string{"python"},
+		MaxTimeout:  1.5,
+		MemoryLimit: 64 << 20,
+	}
+
+	for _, token := range []string{
+		sign(t, jwt.SigningMethodHS256, secret, valid),
+		sign(t, jwt.SigningMethodRS256, rsaKey, valid),
+	} {
+		principal, err := verifier.Verify(token)
+		if err != nil {
+			t.Fatalf("Failed to verify token: %v", err)
+		}
+		if principal.ID != "user-1" || principal.Tenant != "acme" || principal.MaxTimeout != 1500*time.Millisecond || principal.MemoryLimit != 64<<20 {
+			t.Fatalf("Unexpected principal: %+v", principal)
+		}
+		if principal.Owner() != "jwt:user-1" {
+			t.Fatalf("Expected owner jwt:user-1, got %q", principal.Owner())
+		}
+		if !principal.AllowsRuntime("python") || principal.AllowsRuntime("node") {
+			t.Fatalf("Expected only the python runtime to be allowed, got %v", principal.Runtimes)
+		}
+	}
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	otherIssuer := valid
+	otherIssuer.Issuer = "elsewhere"
+	noTenant := valid
+	noTenant.Tenant = ""
+	badPriority := valid
+	badPriority.MaxPriority = "urgent"
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	for name, token := range map[string]string{
+		"expired":      sign(t, jwt.SigningMethodHS256, secret, expired),
+		"wrong secret": sign(t, jwt.SigningMethodHS256, []byte("guess"), valid),
+		"other issuer": sign(t, jwt.SigningMethodHS256, secret, otherIssuer),
+		"no tenant":    sign(t, jwt.SigningMethodHS256, secret, noTenant),
+		"bad priority": sign(t, jwt.SigningMethodHS256, secret, badPriority),
+		"unsigned":     unsigned,
+	} {
+		if _, err := verifier.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Expected %s token to be rejected, got %v", name, err)
+		}
+	}
+}
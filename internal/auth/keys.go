@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go--python-executor/internal/executor"
+	"net/http"
+	"os"
+)
+
+// APIKeyHeader carries the API key of a request
+const APIKeyHeader = "X-API-Key"
+
+// ErrUnauthorized is returned for requests without a valid API key
+var ErrUnauthorized = errors.New("missing or invalid API key")
+
+// Key is an entry of the API key file. Only the SHA-256 hash of the secret
+// is stored, so the file does not reveal the keys.
+type Key struct {
+	// ID names the key in logs and owns the sessions created with it
+	ID string `json:"id"`
+	// SHA256 is the hex-encoded SHA-256 hash of the secret
+	SHA256 string `json:"sha256"`
+	// Tenant is the customer the key belongs to. Keys of the same tenant
+	// share its session limits and quotas and cannot see sessions of other tenants.
+	Tenant string `json:"tenant,omitempty"`
+	// MaxPriority is the highest priority executions with the key may be
+	// queued with. Empty allows normal and batch.
+	MaxPriority string `json:"max_priority,omitempty"`
+}
+
+// keyFile is the layout of the API key file
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// Keys authenticates requests against a set of API keys
+type Keys struct {
+	byHash map[[sha256.Size]byte]Key
+}
+
+// HashSecret returns the hex-encoded SHA-256 hash of secret as stored in
+// the API key file
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadKeys reads the API key file at path
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key file: %v", err)
+	}
+
+	var file keyFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse API key file %s: %v", path, err)
+	}
+	return NewKeys(file.Keys)
+}
+
+// NewKeys builds a key set, rejecting entries without an ID, malformed
+// hashes and duplicates
+func NewKeys(keys []Key) (*Keys, error) {
+	set := &Keys{byHash: make(map[[sha256.Size]byte]Key, len(keys))}
+	ids := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("API key %d has no id", i+1)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("API key %s is listed twice", key.ID)
+		}
+		if _, err := executor.ParsePriority(key.MaxPriority); err != nil {
+			return nil, fmt.Errorf("API key %s: %v", key.ID, err)
+		}
+		decoded, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("API key %s: sha256 must be 64 hex digits", key.ID)
+		}
+
+		var hash [sha256.Size]byte
+		copy(hash[:], decoded)
+		if _, exists := set.byHash[hash]; exists {
+			return nil, fmt.Errorf("API key %s has the same secret as another key", key.ID)
+		}
+		set.byHash[hash] = key
+		ids[key.ID] = true
+	}
+	return set, nil
+}
+
+// Authenticate returns the caller owning secret. Secrets are compared by
+// their hash, so lookups take the same time whatever part of a secret is
+// right.
+func (k *Keys) Authenticate(secret string) (Principal, error) {
+	if secret == "" {
+		return Principal{}, ErrUnauthorized
+	}
+	key, ok := k.byHash[sha256.Sum256([]byte(secret))]
+	if !ok {
+		return Principal{}, ErrUnauthorized
+	}
+	return Principal{Type: CredentialAPIKey, ID: key.ID, Tenant: key.Tenant, MaxPriority: key.MaxPriority}, nil
+}
+
+// AuthenticateRequest authenticates the API key sent with r
+func (k *Keys) AuthenticateRequest(r *http.Request) (Principal, error) {
+	return k.Authenticate(r.Header.Get(APIKeyHeader))
+}
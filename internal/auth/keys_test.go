@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	keys, err := NewKeys([]Key{{ID: "alice", SHA256: HashSecret("s3cret"), Tenant: "acme"}})
+	if err != nil {
+		t.Fatalf("Failed to build keys: %v", err)
+	}
+
+	principal, err := keys.Authenticate("s3cret")
+	if err != nil || principal.ID != "alice" || principal.Tenant != "acme" {
+		t.Fatalf("Expected alice, got %+v, %v", principal, err)
+	}
+	if principal.Owner() != "key:alice" || principal.Account() != "tenant:acme" {
+		t.Fatalf("Expected owner key:alice and account tenant:acme, got %q and %q", principal.Owner(), principal.Account())
+	}
+	for _, secret := range []string{"", "s3cre", HashSecret("s3cret")} {
+		if _, err := keys.Authenticate(secret); err != ErrUnauthorized {
+			t.Fatalf("Expected %q to be rejected, got %v", secret, err)
+		}
+	}
+}
+
+func TestLoadKeysRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		content  string
+		expected string
+	}{
+		{`{"keys": [{"id": "a", "sha256": "abc"}]}`, "64 hex digits"},
+		{`{"keys": [{"sha256": "` + HashSecret("x") + `"}]}`, "has no id"},
+		{`{"keys": [{"id": "a", "sha256": "` + HashSecret("x") + `"}, {"id": "a", "sha256": "` + HashSecret("y") + `"}]}`, "listed twice"},
+		{`{"keys": [{"id": "a", "secret": "x"}]}`, "unknown field"},
+		{`{"keys": [{"id": "a", "sha256": "` + HashSecret("x") + `", "max_priority": "urgent"}]}`, "unknown priority"},
+	} {
+		path := filepath.Join(dir, "keys.json")
+		os.WriteFile(path, []byte(tc.content), 0644)
+		if _, err := LoadKeys(path); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("Expected error containing %q, got %v", tc.expected, err)
+		}
+	}
+}
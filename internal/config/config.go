@@ -0,0 +1,258 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "2s" or "5m" in
+// config files, environment variables and flags
+type Duration time.Duration
+
+// MarshalText formats the duration like time.Duration.String
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText parses a duration string such as "1m30s"
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds every server setting. The json name of a field is also its
+// key in config files, its flag name (with dashes) and, upper-cased with
+// the EnvPrefix, its environment variable. Settings tagged restart cannot
+// be changed by a reload.
+type Config struct {
+	// Server
+	Listen     string `json:"listen" restart:"true" help:"address the HTTP server listens on"`
+	BaseDir    string `json:"base_dir" restart:"true" help:"directory holding the session directories"`
+	PythonPath string `json:"python_path" restart:"true" help:"Python interpreter sessions run with"`
+	SessionDB  string `json:"session_db" restart:"true" help:"path to an embedded database for session state (empty: files in session directories)"`
+	AdminToken string `json:"admin_token" secret:"true" help:"bearer token for the admin endpoints (empty disables them)"`
+	LogLevel   string `json:"log_level" help:"minimum level of logged messages: debug, info, warn or error"`
+	LogFormat  string `json:"log_format" restart:"true" help:"format of log lines: json or text"`
+
+	// Reverse proxies
+	TrustedProxies string `json:"trusted_proxies" help:"comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Real-IP headers name the client"`
+
+	// Authentication
+	APIKeysFile         string `json:"api_keys_file" help:"JSON file of API keys with SHA-256 hashed secrets, re-read on reload"`
+	JWTHMACKeyFile      string `json:"jwt_hmac_key_file" help:"file holding the shared secret of HMAC-signed JWTs, re-read on reload"`
+	JWTRSAPublicKeyFile string `json:"jwt_rsa_public_key_file" help:"PEM file holding the public key of RSA-signed JWTs, re-read on reload"`
+	JWTIssuer           string `json:"jwt_issuer" help:"issuer JWTs must name (empty: any)"`
+	JWTAudience         string `json:"jwt_audience" help:"audience JWTs must name (empty: any)"`
+
+	// Tracing
+	TraceExporter    string  `json:"trace_exporter" restart:"true" help:"where spans are exported: none, otlp or file"`
+	TraceEndpoint    string  `json:"trace_endpoint" restart:"true" help:"OTLP/HTTP endpoint URL (empty: OTEL_EXPORTER_OTLP_ENDPOINT)"`
+	TraceFile        string  `json:"trace_file" restart:"true" help:"file spans are appended to as JSON when trace_exporter is file"`
+	TraceSampleRatio float64 `json:"trace_sample_ratio" restart:"true" help:"fraction of new traces recorded"`
+
+	// Audit
+	AuditLog           string `json:"audit_log" restart:"true" help:"file every execution is recorded in as JSON lines (empty disables the audit log)"`
+	AuditLogMaxSize    int64  `json:"audit_log_max_size" restart:"true" help:"bytes after which the audit log is rotated (0 never rotates)"`
+	AuditLogMaxBackups int    `json:"audit_log_max_backups" restart:"true" help:"rotated audit log files kept (0 keeps all)"`
+	AuditLogCode       bool   `json:"audit_log_code" restart:"true" help:"record the full code of executions besides its hash"`
+
+	// Timeouts
+	ExecutionTimeout   Duration `json:"execution_timeout" help:"limit for a single execution"`
+	SessionTimeLimit   Duration `json:"session_time_limit" help:"default idle timeout of a session"`
+	CleanupInterval    Duration `json:"cleanup_interval" help:"interval between removals of expired sessions"`
+	ReplayTimeout      Duration `json:"replay_timeout" help:"limit for replaying a whole session history"`
+	InstallTimeout     Duration `json:"install_timeout" help:"limit for creating a venv and installing packages"`
+	MaxIdleTimeout     Duration `json:"max_idle_timeout" help:"ceiling for client-requested idle timeouts"`
+	MaxSessionLifetime Duration `json:"max_session_lifetime" help:"ceiling and default for the absolute session lifetime"`
+	ShutdownTimeout    Duration `json:"shutdown_timeout" restart:"true" help:"how long running executions may finish after a shutdown signal"`
+
+	// Limits
+	MaxConcurrent      int      `json:"max_concurrent" help:"maximum number of executions running at once (0 for unlimited)"`
+	MaxQueue           int      `json:"max_queue" help:"maximum number of executions waiting for a slot"`
+	QueueTimeout       Duration `json:"queue_timeout" help:"maximum time an execution waits for a slot"`
+	RetryAfter         Duration `json:"retry_after" help:"Retry-After sent with 429 responses"`
+	InteractiveReserve float64  `json:"interactive_reserve" help:"fraction of max-concurrent reserved for interactive executions"`
+	SessionDiskQuota   int64    `json:"session_disk_quota" help:"maximum bytes of disk space per session (0 for unlimited)"`
+	GlobalDiskQuota    int64    `json:"global_disk_quota" help:"maximum bytes of disk space for all sessions (0 for unlimited)"`
+	DiskQuotaPolicy    string   `json:"disk_quota_policy" help:"what to do when the global disk quota is exceeded: reject or evict"`
+	MaxUploadSize      int64    `json:"max_upload_size" help:"maximum bytes of a single file upload"`
+	RateLimit          float64  `json:"rate_limit" help:"requests per second allowed per API key or tenant (0 for unlimited)"`
+	RateLimitBurst     int      `json:"rate_limit_burst" help:"requests an API key or tenant may send at once (0: rate_limit rounded up)"`
+	DailyCPUSeconds    float64  `json:"daily_cpu_seconds" help:"CPU seconds executions of an API key or tenant may use per UTC day (0 for unlimited)"`
+	MaxAccountSessions int      `json:"max_account_sessions" help:"sessions an API key or tenant may hold at once (0 for unlimited)"`
+	MaxTenantSessions  int      `json:"max_tenant_sessions" help:"sessions the session manager keeps per tenant (0 for unlimited)"`
+
+	// Features
+	StrictSessions   bool   `json:"strict_sessions" help:"reject unknown session IDs on /execute instead of creating them"`
+	Wheelhouse       string `json:"wheelhouse" help:"local directory of wheels sessions may install packages from (empty disables installation)"`
+	WarmPoolSize     int    `json:"warm_pool_size" help:"number of interpreters to keep started ahead of time (0 disables the pool)"`
+	WarmPoolPreload  string `json:"warm_pool_preload" help:"Python script every pooled interpreter runs before use"`
+	ShutdownSessions string `json:"shutdown_sessions" help:"what to do with sessions on shutdown: persist them for the next start or cleanup"`
+
+	// sources remembers where the config was loaded from so it can be
+	// reloaded
+	sources *sources
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Listen:     ":8080",
+		BaseDir:    filepath.Join(os.TempDir(), "python-sessions"),
+		PythonPath: "python3",
+		LogLevel:   "info",
+		LogFormat:  "json",
+
+		TraceExporter:    "none",
+		TraceSampleRatio: 1,
+
+		AuditLogMaxSize:    100 << 20,
+		AuditLogMaxBackups: 10,
+
+		ExecutionTimeout:   Duration(2 * time.Second),
+		SessionTimeLimit:   Duration(5 * time.Minute),
+		CleanupInterval:    Duration(30 * time.Second),
+		ReplayTimeout:      Duration(30 * time.Second),
+		InstallTimeout:     Duration(5 * time.Minute),
+		MaxIdleTimeout:     Duration(time.Hour),
+		MaxSessionLifetime: Duration(24 * time.Hour),
+		ShutdownTimeout:    Duration(30 * time.Second),
+
+		MaxConcurrent:   runtime.NumCPU(),
+		MaxQueue:        100,
+		QueueTimeout:    Duration(10 * time.Second),
+		RetryAfter:      Duration(time.Second),
+		DiskQuotaPolicy: "reject",
+		MaxUploadSize:   32 << 20,
+
+		ShutdownSessions: "persist",
+	}
+}
+
+// Validate checks that the configuration is usable and returns every
+// problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Listen != "", "listen must not be empty")
+	check(c.BaseDir != "", "base_dir must not be empty")
+	check(c.LogLevel == "debug" || c.LogLevel == "info" || c.LogLevel == "warn" || c.LogLevel == "error", "log_level must be debug, info, warn or error")
+	check(c.LogFormat == "json" || c.LogFormat == "text", "log_format must be json or text")
+	check(c.TraceExporter == "none" || c.TraceExporter == "otlp" || c.TraceExporter == "file", "trace_exporter must be none, otlp or file")
+	check(c.TraceExporter != "file" || c.TraceFile != "", "trace_file must be set when trace_exporter is file")
+	check(c.TraceSampleRatio >= 0 && c.TraceSampleRatio <= 1, "trace_sample_ratio must be between 0 and 1")
+	check(c.AuditLogMaxSize >= 0, "audit_log_max_size must not be negative")
+	check(c.AuditLogMaxBackups >= 0, "audit_log_max_backups must not be negative")
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %v", err))
+	}
+	if _, err := exec.LookPath(c.PythonPath); err != nil {
+		errs = append(errs, fmt.Errorf("python_path: %v", err))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"execution_timeout", c.ExecutionTimeout},
+		{"session_time_limit", c.SessionTimeLimit},
+		{"cleanup_interval", c.CleanupInterval},
+		{"replay_timeout", c.ReplayTimeout},
+		{"install_timeout", c.InstallTimeout},
+	} {
+		check(d.value > 0, "%s must be positive", d.name)
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"max_idle_timeout", c.MaxIdleTimeout},
+		{"max_session_lifetime", c.MaxSessionLifetime},
+		{"queue_timeout", c.QueueTimeout},
+		{"retry_after", c.RetryAfter},
+		{"shutdown_timeout", c.ShutdownTimeout},
+	} {
+		check(d.value >= 0, "%s must not be negative", d.name)
+	}
+
+	check(c.MaxConcurrent >= 0, "max_concurrent must not be negative")
+	check(c.MaxQueue >= 0, "max_queue must not be negative")
+	check(c.InteractiveReserve >= 0 && c.InteractiveReserve <= 1, "interactive_reserve must be between 0 and 1")
+	check(c.SessionDiskQuota >= 0, "session_disk_quota must not be negative")
+	check(c.GlobalDiskQuota >= 0, "global_disk_quota must not be negative")
+	check(c.DiskQuotaPolicy == "reject" || c.DiskQuotaPolicy == "evict", "disk_quota_policy must be reject or evict")
+	check(c.MaxUploadSize > 0, "max_upload_size must be positive")
+	check(c.RateLimit >= 0, "rate_limit must not be negative")
+	check(c.RateLimitBurst >= 0, "rate_limit_burst must not be negative")
+	check(c.DailyCPUSeconds >= 0, "daily_cpu_seconds must not be negative")
+	check(c.MaxAccountSessions >= 0, "max_account_sessions must not be negative")
+	check(c.MaxTenantSessions >= 0, "max_tenant_sessions must not be negative")
+	check(c.WarmPoolSize >= 0, "warm_pool_size must not be negative")
+	check(c.ShutdownSessions == "persist" || c.ShutdownSessions == "cleanup", "shutdown_sessions must be persist or cleanup")
+
+	if c.Wheelhouse != "" {
+		info, err := os.Stat(c.Wheelhouse)
+		check(err == nil && info.IsDir(), "wheelhouse %s is not a directory", c.Wheelhouse)
+	}
+	for _, f := range []struct {
+		name string
+		path string
+	}{
+		{"api_keys_file", c.APIKeysFile},
+		{"jwt_hmac_key_file", c.JWTHMACKeyFile},
+		{"jwt_rsa_public_key_file", c.JWTRSAPublicKeyFile},
+	} {
+		if f.path != "" {
+			_, err := os.Stat(f.path)
+			check(err == nil, "%s %s does not exist", f.name, f.path)
+		}
+	}
+	if c.WarmPoolPreload != "" {
+		_, err := os.Stat(c.WarmPoolPreload)
+		check(err == nil, "warm_pool_preload %s does not exist", c.WarmPoolPreload)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR ranges. An address stands for a range of just that address.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv function backed by a map
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, printConfig, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+	if printConfig {
+		t.Fatal("Expected print-config to be off")
+	}
+	if cfg.Listen != ":8080" || time.Duration(cfg.ExecutionTimeout) != 2*time.Second {
+		t.Fatalf("Unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.yaml")
+	content := "listen: \":9000\"\nexecution_timeout: 5s\nmax_queue: 7\nstrict_sessions: true\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, _, err := Load(
+		[]string{"-config", file, "-max-queue", "11", "-print-config"},
+		env(map[string]string{"PYEXEC_EXECUTION_TIMEOUT": "3s", "PYEXEC_MAX_QUEUE": "9"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// The file overrides defaults, the environment overrides the file and
+	// flags override everything
+	if cfg.Listen != ":9000" {
+		t.Fatalf("Expected listen from file, got %q", cfg.Listen)
+	}
+	if !cfg.StrictSessions {
+		t.Fatal("Expected strict_sessions from file")
+	}
+	if time.Duration(cfg.ExecutionTimeout) != 3*time.Second {
+		t.Fatalf("Expected execution_timeout from environment, got %v", time.Duration(cfg.ExecutionTimeout))
+	}
+	if cfg.MaxQueue != 11 {
+		t.Fatalf("Expected max_queue from flag, got %d", cfg.MaxQueue)
+	}
+	if cfg.File() != file {
+		t.Fatalf("Expected config file %s, got %s", file, cfg.File())
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"server.json": `{"max_queue": 5, "queue_timeout": "1s"}`,
+		"server.yml":  "max_queue: 5\nqueue_timeout: 1s\n",
+		"server.toml": "max_queue = 5\nqueue_timeout = \"1s\"\n",
+	} {
+		file := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+
+		cfg, _, err := Load(nil, env(map[string]string{"PYEXEC_CONFIG": file}))
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		if cfg.MaxQueue != 5 || time.Duration(cfg.QueueTimeout) != time.Second {
+			t.Fatalf("Unexpected settings from %s: %+v", name, cfg)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.json")
+	os.WriteFile(unknown, []byte(`{"max_queues": 5}`), 0644)
+	unsupported := filepath.Join(dir, "server.ini")
+	os.WriteFile(unsupported, []byte("max_queue=5"), 0644)
+
+	for _, tc := range []struct {
+		args     []string
+		vars     map[string]string
+		expected string
+	}{
+		{[]string{"-config", unknown}, nil, "unknown field"},
+		{[]string{"-config", unsupported}, nil, "unsupported config file format"},
+		{[]string{"-disk-quota-policy", "drop"}, nil, "disk_quota_policy"},
+		{[]string{"-interactive-reserve", "2"}, nil, "interactive_reserve"},
+		{[]string{"-log-format", "xml"}, nil, "log_format"},
+		{[]string{"-trace-exporter", "file"}, nil, "trace_file must be set"},
+		{[]string{"-python-path", "no-such-python"}, nil, "python_path"},
+		{[]string{"-trusted-proxies", "10.0.0.0/8,caddy"}, nil, "trusted_proxies"},
+		{nil, map[string]string{"PYEXEC_EXECUTION_TIMEOUT": "0s"}, "execution_timeout must be positive"},
+		{nil, map[string]string{"PYEXEC_MAX_QUEUE": "many"}, "PYEXEC_MAX_QUEUE"},
+		{[]string{"extra"}, nil, "unexpected arguments"},
+	} {
+		_, _, err := Load(tc.args, env(tc.vars))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("Expected error containing %q for %v %v, got: %v", tc.expected, tc.args, tc.vars, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(file, []byte(`{"max_queue": 5}`), 0644)
+
+	cfg, _, err := Load([]string{"-config", file, "-max-concurrent", "3"}, env(nil))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	os.WriteFile(file, []byte(`{"max_queue": 8, "max_concurrent": 6}`), 0644)
+	reloaded, err := cfg.Reload()
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	// Flags still take precedence over the changed file
+	if reloaded.MaxQueue != 8 || reloaded.MaxConcurrent != 3 {
+		t.Fatalf("Unexpected reloaded settings: max_queue %d, max_concurrent %d", reloaded.MaxQueue, reloaded.MaxConcurrent)
+	}
+}
+
+func TestApply(t *testing.T) {
+	current := Default()
+	current.AdminToken = "old-token"
+
+	next := Default()
+	next.Listen = ":9999"
+	next.ExecutionTimeout = Duration(5 * time.Second)
+	next.AdminToken = "new-token"
+
+	merged, changes := Apply(current, next)
+
+	// Settings that need a restart keep their values
+	if merged.Listen != current.Listen {
+		t.Fatalf("Expected listen to stay %q, got %q", current.Listen, merged.Listen)
+	}
+	if time.Duration(merged.ExecutionTimeout) != 5*time.Second || merged.AdminToken != "new-token" {
+		t.Fatalf("Expected reloadable settings to change, got: %+v", merged)
+	}
+
+	expected := map[string]Change{
+		"listen":            {Setting: "listen", Old: ":8080", New: ":9999", Applied: false},
+		"admin_token":       {Setting: "admin_token", Old: redactedSecret, New: redactedSecret, Applied: true},
+		"execution_timeout": {Setting: "execution_timeout", Old: "2s", New: "5s", Applied: true},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got: %+v", len(expected), changes)
+	}
+	for _, change := range changes {
+		if change != expected[change.Setting] {
+			t.Fatalf("Expected %+v, got %+v", expected[change.Setting], change)
+		}
+	}
+}
+
+func TestWriteRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.AdminToken = "s3cret"
+
+	var out strings.Builder
+	if err := cfg.Write(&out); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if strings.Contains(out.String(), "s3cret") || !strings.Contains(out.String(), redactedSecret) {
+		t.Fatalf("Expected the admin token to be redacted, got: %s", out.String())
+	}
+	if cfg.AdminToken != "s3cret" {
+		t.Fatal("Expected the config itself to keep the token")
+	}
+}
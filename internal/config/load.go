@@ -0,0 +1,277 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variable of every setting, e.g.
+// PYEXEC_EXECUTION_TIMEOUT for execution_timeout
+const EnvPrefix = "PYEXEC_"
+
+// redactedSecret replaces secret settings in printed configurations and
+// change reports
+const redactedSecret = "<redacted>"
+
+// sources are the inputs a configuration is built from
+type sources struct {
+	file   string
+	getenv func(string) string
+	flags  map[string]string
+}
+
+// field is a setting of Config
+type field struct {
+	name    string
+	help    string
+	index   int
+	restart bool
+	secret  bool
+}
+
+// fields lists the settings of Config in declaration order
+func fields() []field {
+	var list []field
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("json")
+		if name == "" {
+			continue
+		}
+		list = append(list, field{
+			name:    name,
+			help:    t.Field(i).Tag.Get("help"),
+			index:   i,
+			restart: t.Field(i).Tag.Get("restart") == "true",
+			secret:  t.Field(i).Tag.Get("secret") == "true",
+		})
+	}
+	return list
+}
+
+// flagName is the command-line flag of a setting
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+// envName is the environment variable of a setting
+func (f field) envName() string {
+	return EnvPrefix + strings.ToUpper(f.name)
+}
+
+// flagValue collects the raw value of a flag so that flags can be applied
+// after the config file and environment
+type flagValue struct {
+	name   string
+	def    string
+	isBool bool
+	values map[string]string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(raw string) error {
+	v.values[v.name] = raw
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Load builds the configuration from the defaults, a config file, the
+// environment and the command-line flags, each overriding the previous one,
+// and validates it. It also reports whether -print-config was given.
+func Load(args []string, getenv func(string) string) (*Config, bool, error) {
+	src := &sources{getenv: getenv, flags: make(map[string]string)}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&src.file, "config", getenv(EnvPrefix+"CONFIG"), "config file in JSON, YAML or TOML format (env "+EnvPrefix+"CONFIG)")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+
+	defaults := reflect.ValueOf(Default()).Elem()
+	for _, f := range fields() {
+		value := defaults.Field(f.index)
+		flags.Var(&flagValue{
+			name:   f.name,
+			def:    format(value),
+			isBool: value.Kind() == reflect.Bool,
+			values: src.flags,
+		}, f.flagName(), fmt.Sprintf("%s (env %s)", f.help, f.envName()))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if flags.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	cfg, err := src.build()
+	if err != nil {
+		return nil, false, err
+	}
+	return cfg, *printConfig, nil
+}
+
+// Reload builds the configuration again from the same config file,
+// environment and flags, picking up changes to the file
+func (c *Config) Reload() (*Config, error) {
+	if c.sources == nil {
+		return nil, errors.New("configuration was not loaded from sources")
+	}
+	return c.sources.build()
+}
+
+// File returns the path of the config file, if any
+func (c *Config) File() string {
+	if c.sources == nil {
+		return ""
+	}
+	return c.sources.file
+}
+
+// build layers the sources over the defaults and validates the result
+func (src *sources) build() (*Config, error) {
+	cfg := Default()
+	cfg.sources = src
+
+	if src.file != "" {
+		if err := cfg.loadFile(src.file); err != nil {
+			return nil, err
+		}
+	}
+
+	value := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields() {
+		if raw := src.getenv(f.envName()); raw != "" {
+			if err := set(value.Field(f.index), raw); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", f.envName(), err)
+			}
+		}
+	}
+	for _, f := range fields() {
+		if raw, ok := src.flags[f.name]; ok {
+			if err := set(value.Field(f.index), raw); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %v", f.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadFile reads settings from a JSON, YAML or TOML file, chosen by its
+// extension. Unknown keys are rejected.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// YAML and TOML are converted to JSON so that the json names apply to
+	// every format
+	var settings map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("unsupported config file format %q: use .json, .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if settings != nil {
+		if data, err = json.Marshal(settings); err != nil {
+			return fmt.Errorf("failed to parse config file: %v", err)
+		}
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// set parses raw into the setting v
+func set(v reflect.Value, raw string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// format returns the value of a setting as it would be written in a flag
+func format(v reflect.Value) string {
+	if marshaler, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := marshaler.MarshalText()
+		return string(text)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Write prints the configuration as indented JSON with secrets redacted
+func (c *Config) Write(w io.Writer) error {
+	redacted := *c
+	value := reflect.ValueOf(&redacted).Elem()
+	for _, f := range fields() {
+		if f.secret && value.Field(f.index).String() != "" {
+			value.Field(f.index).SetString(redactedSecret)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&redacted)
+}
@@ -0,0 +1,39 @@
+package config
+
+import "reflect"
+
+// Change describes a setting that differs between two configurations
+type Change struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	// Applied is false for settings that only take effect after a restart
+	Applied bool `json:"applied"`
+}
+
+// Apply returns the configuration to run with after reloading next while
+// running with current, together with every changed setting. Settings that
+// need a restart keep their current values.
+func Apply(current, next *Config) (*Config, []Change) {
+	merged := *next
+	currentValue := reflect.ValueOf(current).Elem()
+	mergedValue := reflect.ValueOf(&merged).Elem()
+
+	var changes []Change
+	for _, f := range fields() {
+		old, updated := currentValue.Field(f.index), mergedValue.Field(f.index)
+		if format(old) == format(updated) {
+			continue
+		}
+
+		change := Change{Setting: f.name, Old: format(old), New: format(updated), Applied: !f.restart}
+		if f.secret {
+			change.Old, change.New = redactedSecret, redactedSecret
+		}
+		if f.restart {
+			updated.Set(old)
+		}
+		changes = append(changes, change)
+	}
+	return &merged, changes
+}
@@ -0,0 +1,251 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when every slot is busy and the wait queue
+	// is at capacity
+	ErrQueueFull = errors.New("execution queue is full")
+	// ErrQueueTimeout is returned when no slot became free within the
+	// queue timeout
+	ErrQueueTimeout = errors.New("timed out waiting for an execution slot")
+)
+
+// Options configures a Limiter
+type Options struct {
+	// MaxConcurrent is the number of executions that may run at once. Zero
+	// means unlimited.
+	MaxConcurrent int
+	// MaxQueue is the number of executions that may wait for a slot
+	MaxQueue int
+	// QueueTimeout limits how long an execution waits for a slot. Zero
+	// means it waits as long as its context allows.
+	QueueTimeout time.Duration
+	// InteractiveReserve is the fraction of MaxConcurrent that only
+	// interactive executions may use
+	InteractiveReserve float64
+}
+
+// Stats describes the state of a Limiter
+type Stats struct {
+	MaxConcurrent    int            `json:"max_concurrent"`
+	MaxQueue         int            `json:"max_queue"`
+	Reserved         int            `json:"reserved_interactive"`
+	Running          int            `json:"running"`
+	Queued           int            `json:"queued"`
+	QueuedByPriority map[string]int `json:"queued_by_priority"`
+	Admitted         int64          `json:"admitted"`
+	Rejected         int64          `json:"rejected"`
+	TimedOut         int64          `json:"timed_out"`
+}
+
+// Limiter bounds the number of concurrently running executions and queues
+// the excess, dispatching higher priorities first and each priority in
+// arrival order
+type Limiter struct {
+	mutex       sync.Mutex
+	opts        Options
+	running     int
+	interactive int
+	queues      [Interactive + 1][]*waiter
+	admitted    int64
+	rejected    int64
+	timedOut    int64
+}
+
+// waiter is an execution queued for a slot. ready is closed once the slot
+// is granted.
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+}
+
+// New creates a limiter
+func New(opts Options) *Limiter {
+	return &Limiter{opts: opts}
+}
+
+// SetOptions changes the limits. Executions already running keep their
+// slots; queued executions are admitted if the new limits allow.
+func (l *Limiter) SetOptions(opts Options) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.opts = opts
+	l.dispatch()
+}
+
+// Acquire waits for an execution slot at the priority carried by ctx and
+// returns the function that frees it. It fails with ErrQueueFull when the
+// queue is at capacity, with ErrQueueTimeout when the queue timeout passes,
+// or with the context error.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	priority := PriorityFrom(ctx)
+
+	l.mutex.Lock()
+	if !l.queuedAtOrAbove(priority) && l.hasCapacity(priority) {
+		l.start(priority)
+		l.mutex.Unlock()
+		return l.releaser(priority), nil
+	}
+	if l.queued() >= l.opts.MaxQueue {
+		l.rejected++
+		l.mutex.Unlock()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{priority: priority, ready: make(chan struct{})}
+	l.queues[priority] = append(l.queues[priority], w)
+	timeout := l.opts.QueueTimeout
+	l.mutex.Unlock()
+
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case <-w.ready:
+		return l.releaser(priority), nil
+	case <-waitCtx.Done():
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.remove(w) {
+		// The slot was granted while giving up, so use it
+		return l.releaser(priority), nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	l.timedOut++
+	return nil, ErrQueueTimeout
+}
+
+// Stats reports the current limits, queue depth and counters
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := Stats{
+		MaxConcurrent:    l.opts.MaxConcurrent,
+		MaxQueue:         l.opts.MaxQueue,
+		Reserved:         l.reserved(),
+		Running:          l.running,
+		Queued:           l.queued(),
+		QueuedByPriority: make(map[string]int),
+		Admitted:         l.admitted,
+		Rejected:         l.rejected,
+		TimedOut:         l.timedOut,
+	}
+	for _, p := range priorities {
+		stats.QueuedByPriority[p.String()] = len(l.queues[p])
+	}
+	return stats
+}
+
+// Saturated reports whether every slot is busy and the queue is full, so
+// that new executions are refused
+func (l *Limiter) Saturated() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.opts.MaxConcurrent > 0 && l.running >= l.opts.MaxConcurrent && l.queued() >= l.opts.MaxQueue
+}
+
+// releaser returns a function that frees a slot once
+func (l *Limiter) releaser(priority Priority) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.running--
+			if priority == Interactive {
+				l.interactive--
+			}
+			l.dispatch()
+		})
+	}
+}
+
+// start records a granted slot. Callers must hold l.mutex.
+func (l *Limiter) start(priority Priority) {
+	l.running++
+	if priority == Interactive {
+		l.interactive++
+	}
+	l.admitted++
+}
+
+// reserved is the number of slots kept for interactive executions.
+// Callers must hold l.mutex.
+func (l *Limiter) reserved() int {
+	if l.opts.MaxConcurrent <= 0 || l.opts.InteractiveReserve <= 0 {
+		return 0
+	}
+	return min(int(l.opts.InteractiveReserve*float64(l.opts.MaxConcurrent)), l.opts.MaxConcurrent)
+}
+
+// hasCapacity reports whether another execution of the given priority may
+// start. Callers must hold l.mutex.
+func (l *Limiter) hasCapacity(priority Priority) bool {
+	if l.opts.MaxConcurrent <= 0 {
+		return true
+	}
+	if l.running >= l.opts.MaxConcurrent {
+		return false
+	}
+	return priority == Interactive || l.running-l.interactive < l.opts.MaxConcurrent-l.reserved()
+}
+
+// queued is the number of executions waiting for a slot. Callers must hold
+// l.mutex.
+func (l *Limiter) queued() int {
+	n := 0
+	for _, queue := range l.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// queuedAtOrAbove reports whether executions of the given or a higher
+// priority are waiting. Callers must hold l.mutex.
+func (l *Limiter) queuedAtOrAbove(priority Priority) bool {
+	for p := priority; p <= Interactive; p++ {
+		if len(l.queues[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch grants free slots to queued executions, highest priority first
+// and in arrival order within a priority. Callers must hold l.mutex.
+func (l *Limiter) dispatch() {
+	for _, p := range priorities {
+		for len(l.queues[p]) > 0 && l.hasCapacity(p) {
+			w := l.queues[p][0]
+			l.queues[p] = l.queues[p][1:]
+			l.start(p)
+			close(w.ready)
+		}
+	}
+}
+
+// remove takes a waiter off its queue and reports whether it was still
+// queued. Callers must hold l.mutex.
+func (l *Limiter) remove(w *waiter) bool {
+	queue := l.queues[w.priority]
+	for i, queued := range queue {
+		if queued == w {
+			l.queues[w.priority] = append(queue[:i], queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
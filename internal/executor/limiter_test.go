@@ -0,0 +1,191 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAdmitsUpToMaxConcurrent(t *testing.T) {
+	limiter := New(Options{MaxConcurrent: 2, MaxQueue: 1, QueueTimeout: time.Second})
+
+	first, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to acquire first slot: %v", err)
+	}
+	second, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to acquire second slot: %v", err)
+	}
+
+	// The third waits in the queue until a slot is freed
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := limiter.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for limiter.Stats().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 queued execution, got: %+v", limiter.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got: %v", err)
+	}
+
+	first()
+	first() // Releasing twice frees the slot only once
+	if err := <-acquired; err != nil {
+		t.Fatalf("Expected queued execution to be admitted, got: %v", err)
+	}
+	second()
+
+	stats := limiter.Stats()
+	if stats.Running != 0 || stats.Queued != 0 || stats.Admitted != 3 || stats.Rejected != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	limiter := New(Options{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to acquire slot: %v", err)
+	}
+	defer release()
+
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Expected ErrQueueTimeout, got: %v", err)
+	}
+
+	// A cancelled context is reported as such
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+
+	stats := limiter.Stats()
+	if stats.Queued != 0 || stats.TimedOut != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiterSetOptions(t *testing.T) {
+	limiter := New(Options{MaxConcurrent: 1, MaxQueue: 1})
+
+	release, _ := limiter.Acquire(context.Background())
+	defer release()
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := limiter.Acquire(context.Background())
+		acquired <- err
+	}()
+	for limiter.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Raising the limit admits the queued execution
+	limiter.SetOptions(Options{MaxConcurrent: 2, MaxQueue: 1})
+	if err := <-acquired; err != nil {
+		t.Fatalf("Expected queued execution to be admitted, got: %v", err)
+	}
+	if limiter.Stats().Running != 2 {
+		t.Fatalf("Expected 2 running executions, got: %+v", limiter.Stats())
+	}
+}
+
+// waitQueued waits until n executions are queued
+func waitQueued(t *testing.T, limiter *Limiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for limiter.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued executions, got: %+v", n, limiter.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterDispatchesByPriority(t *testing.T) {
+	limiter := New(Options{MaxConcurrent: 1, MaxQueue: 10})
+
+	release, _ := limiter.Acquire(context.Background())
+
+	// Queue batch, normal and interactive executions in that order
+	order := make(chan Priority, 3)
+	for i, p := range []Priority{Batch, Normal, Interactive} {
+		go func() {
+			release, err := limiter.Acquire(WithPriority(context.Background(), p))
+			if err != nil {
+				t.Errorf("Failed to acquire slot: %v", err)
+				return
+			}
+			order <- p
+			release()
+		}()
+		waitQueued(t, limiter, i+1)
+	}
+
+	if queued := limiter.Stats().QueuedByPriority; queued["batch"] != 1 || queued["interactive"] != 1 {
+		t.Fatalf("Unexpected queued executions by priority: %v", queued)
+	}
+
+	release()
+	for _, expected := range []Priority{Interactive, Normal, Batch} {
+		if p := <-order; p != expected {
+			t.Fatalf("Expected %s to be dispatched next, got %s", expected, p)
+		}
+	}
+}
+
+func TestLimiterInteractiveReserve(t *testing.T) {
+	limiter := New(Options{MaxConcurrent: 4, MaxQueue: 10, InteractiveReserve: 0.5})
+
+	if reserved := limiter.Stats().Reserved; reserved != 2 {
+		t.Fatalf("Expected 2 reserved slots, got %d", reserved)
+	}
+
+	// Batch work may only use the unreserved half
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Acquire(WithPriority(context.Background(), Batch)); err != nil {
+			t.Fatalf("Failed to acquire slot: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(WithPriority(context.Background(), Normal), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected normal execution to wait, got: %v", err)
+	}
+
+	// Interactive work can still use the reserved slots
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Acquire(WithPriority(context.Background(), Interactive)); err != nil {
+			t.Fatalf("Failed to acquire reserved slot: %v", err)
+		}
+	}
+	if running := limiter.Stats().Running; running != 4 {
+		t.Fatalf("Expected 4 running executions, got %d", running)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for name, expected := range map[string]Priority{"": Normal, "normal": Normal, "batch": Batch, "interactive": Interactive} {
+		if p, err := ParsePriority(name); err != nil || p != expected {
+			t.Fatalf("Expected %q to parse as %s, got %s (%v)", name, expected, p, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Fatal("Expected an error for an unknown priority")
+	}
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+)
+
+// Priority orders queued executions; higher priorities are dispatched first
+type Priority int
+
+// Priority classes from lowest to highest
+const (
+	Batch Priority = iota
+	Normal
+	Interactive
+)
+
+// priorities lists every class from highest to lowest
+var priorities = []Priority{Interactive, Normal, Batch}
+
+// String returns the name of the priority as used in requests
+func (p Priority) String() string {
+	switch p {
+	case Batch:
+		return "batch"
+	case Interactive:
+		return "interactive"
+	default:
+		return "normal"
+	}
+}
+
+// ParsePriority converts a priority name to a Priority. An empty name is
+// Normal.
+func ParsePriority(name string) (Priority, error) {
+	switch name {
+	case "", "normal":
+		return Normal, nil
+	case "batch":
+		return Batch, nil
+	case "interactive":
+		return Interactive, nil
+	}
+	return Normal, fmt.Errorf("unknown priority %q: must be interactive, normal or batch", name)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose executions are queued with p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority carried by ctx, or Normal
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return Normal
+}
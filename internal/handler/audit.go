@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"go--python-executor/internal/audit"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/logging"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultAuditLimit caps the records of an audit query that sets no limit
+const defaultAuditLimit = 1000
+
+// SetAuditLog makes every execution be recorded in log; nil stops auditing
+func SetAuditLog(log *audit.Log) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	auditLog = log
+}
+
+// auditExecution records an execution on behalf of r in the audit log, if
+// there is one
+func auditExecution(r *http.Request, sessionID, code, outcome string, usage session.Usage, duration time.Duration, err error) {
+	writeAudit(r, executionRecord(sessionID, code, outcome, usage, duration, err))
+}
+
+// auditReplay records the replay of the history of session replayedFrom,
+// given as code, into a new session on behalf of r in the audit log, if
+// there is one
+func auditReplay(r *http.Request, sessionID, replayedFrom, code, outcome string, usage session.Usage, duration time.Duration, err error) {
+	rec := executionRecord(sessionID, code, outcome, usage, duration, err)
+	rec.ReplayedFrom = replayedFrom
+	writeAudit(r, rec)
+}
+
+// executionRecord describes an execution for the audit log
+func executionRecord(sessionID, code, outcome string, usage session.Usage, duration time.Duration, err error) audit.Record {
+	rec := audit.Record{
+		SessionID:  sessionID,
+		Code:       code,
+		Outcome:    outcome,
+		DurationMs: duration.Milliseconds(),
+		CPUMs:      usage.CPUTime.Milliseconds(),
+		WallMs:     usage.WallTime.Milliseconds(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// writeAudit completes rec with the time and the caller of r and appends it
+// to the audit log, if there is one. Failing to record it is logged but does
+// not fail the request.
+func writeAudit(r *http.Request, rec audit.Record) {
+	log := settings().AuditLog
+	if log == nil {
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	rec.Time = time.Now().UTC()
+	rec.RequestID = logging.RequestID(r.Context())
+	rec.Tenant = principal.Tenant
+	rec.Key = principal.Owner()
+	rec.ClientIP = clientIP(r)
+	if err := log.Write(rec); err != nil {
+		slog.Error("Failed to write audit record", "session_id", rec.SessionID, "error", err)
+	}
+}
+
+// AuditHandler returns the newest audit records selected by the session,
+// tenant, since and until (RFC 3339) and limit query parameters on behalf
+// of an admin. A session is looked up in the given tenant, or in the
+// default tenant without one.
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	log := settings().AuditLog
+	if log == nil {
+		sendErrorResponse(w, http.StatusNotFound, models.CodeInvalidRequest, "", "audit log is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		SessionID: query.Get("session"),
+		Tenant:    query.Get("tenant"),
+		Limit:     defaultAuditLimit,
+	}
+	for name, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", name+" must be an RFC 3339 time")
+				return
+			}
+			*bound = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", "limit must be a positive number")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := log.Query(filter)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, "", err.Error())
+		return
+	}
+
+	response := models.AuditResponse{Records: make([]models.AuditRecord, 0, len(records))}
+	for _, rec := range records {
+		response.Records = append(response.Records, models.AuditRecord{
+			Time:         rec.Time.UTC().Format(time.RFC3339Nano),
+			RequestID:    rec.RequestID,
+			Tenant:       rec.Tenant,
+			Key:          rec.Key,
+			ClientIP:     rec.ClientIP,
+			SessionID:    rec.SessionID,
+			ReplayedFrom: rec.ReplayedFrom,
+			CodeSHA256:   rec.CodeSHA256,
+			Code:         rec.Code,
+			Outcome:      rec.Outcome,
+			Error:        rec.Error,
+			DurationMs:   rec.DurationMs,
+			CPUMs:        rec.CPUMs,
+			WallMs:       rec.WallMs,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"go--python-executor/internal/audit"
+	"go--python-executor/internal/models"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	log, err := audit.Open(audit.Options{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	settingsMutex.Lock()
+	AdminToken = "secret"
+	settingsMutex.Unlock()
+	defer func() {
+		settingsMutex.Lock()
+		AdminToken = ""
+		settingsMutex.Unlock()
+	}()
+
+	// Nothing is recorded while auditing is disabled
+	if resp := getJSON(t, server.URL+"/admin/audit", "secret", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404, got %d", resp.StatusCode)
+	}
+
+	SetAuditLog(log)
+	defer SetAuditLog(nil)
+	since := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+
+	response, _ := executeCode(t, server, "x = 1", "")
+	id := response.ID
+	executeCode(t, server, "print(undefined_variable)", id)
+	executeCode(t, server, "print('other')", "")
+	resp, err := http.Post(server.URL+"/sessions/"+id+"/replay", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to replay session: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp := getJSON(t, server.URL+"/admin/audit", "wrong", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status code 401, got %d", resp.StatusCode)
+	}
+
+	var result models.AuditResponse
+	resp = getJSON(t, server.URL+"/admin/audit?session="+id+"&since="+url.QueryEscape(since), "secret", &result)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if len(result.Records) != 3 {
+		t.Fatalf("Expected 2 records of the session and its replay, got %+v", result.Records)
+	}
+
+	// Records are returned newest first
+	replay, second, first := result.Records[0], result.Records[1], result.Records[2]
+	if first.Outcome != "ok" || second.Outcome != "error" {
+		t.Fatalf("Expected outcomes ok and error, got %s and %s", first.Outcome, second.Outcome)
+	}
+	if first.CodeSHA256 != audit.HashCode("x = 1") || first.Code != "" {
+		t.Fatalf("Expected only the hash of the code, got %+v", first)
+	}
+	if first.ClientIP != "127.0.0.1" || first.WallMs <= 0 {
+		t.Fatalf("Expected the client address and resource usage, got %+v", first)
+	}
+
+	// The replay re-executes the successful code of the session
+	if replay.ReplayedFrom != id || replay.SessionID == id || replay.Outcome != "ok" || replay.CodeSHA256 != audit.HashCode("x = 1") {
+		t.Fatalf("Unexpected replay record: %+v", replay)
+	}
+
+	// The session is looked up in the given tenant only
+	result = models.AuditResponse{}
+	getJSON(t, server.URL+"/admin/audit?session="+id+"&tenant=acme", "secret", &result)
+	if len(result.Records) != 0 {
+		t.Fatalf("Expected no records of the session in another tenant, got %d", len(result.Records))
+	}
+
+	// The limit keeps the newest records
+	result = models.AuditResponse{}
+	getJSON(t, server.URL+"/admin/audit?session="+id+"&limit=1", "secret", &result)
+	if len(result.Records) != 1 || result.Records[0].ReplayedFrom != id {
+		t.Fatalf("Expected only the replay, got %+v", result.Records)
+	}
+
+	// Time ranges before the executions select nothing
+	result = models.AuditResponse{}
+	getJSON(t, server.URL+"/admin/audit?until="+url.QueryEscape(since), "secret", &result)
+	if len(result.Records) != 0 {
+		t.Fatalf("Expected no records, got %d", len(result.Records))
+	}
+
+	if resp := getJSON(t, server.URL+"/admin/audit?since=yesterday", "secret", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400, got %d", resp.StatusCode)
+	}
+}
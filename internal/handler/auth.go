@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"net/http"
+	"time"
+)
+
+// errSessionForbidden is reported when a caller touches a session created
+// by someone else
+var errSessionForbidden = errors.New("session belongs to another caller")
+
+// authenticated requires a valid API key or JWT, unless authentication is
+// disabled, and applies the caller's rate limit before calling next
+func authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authn := settings().Authenticator; authn != nil {
+			principal, err := authn.AuthenticateRequest(r)
+			if err != nil {
+				if authn.JWT != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				} else {
+					w.Header().Set("WWW-Authenticate", `ApiKey header="`+auth.APIKeyHeader+`"`)
+				}
+				sendErrorResponse(w, http.StatusUnauthorized, models.CodePolicyViolation, "", err.Error())
+				return
+			}
+			if err := session.ValidateTenant(principal.Tenant); err != nil {
+				sendErrorResponse(w, http.StatusForbidden, models.CodePolicyViolation, "", err.Error())
+				return
+			}
+			r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+		}
+
+		if !allowRequest(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// owner returns the owner sessions created by r are bound to, which is
+// empty when authentication is disabled
+func owner(r *http.Request) string {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	return principal.Owner()
+}
+
+// tenantSessions returns the sessions of the tenant of the caller of r.
+// Callers without a tenant, including every caller when authentication is
+// disabled, share the default tenant.
+func tenantSessions(r *http.Request) *session.Tenant {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	return getSessionManager().Tenant(principal.Tenant)
+}
+
+// authorizeSession reports whether r may use sess and sends 403 Forbidden
+// otherwise
+func authorizeSession(w http.ResponseWriter, r *http.Request, sess *session.Session) bool {
+	if sess.Owner() != owner(r) {
+		sendErrorResponse(w, http.StatusForbidden, models.CodePolicyViolation, sess.ID, errSessionForbidden.Error())
+		return false
+	}
+	return true
+}
+
+// lookupSession returns the existing session id on behalf of r, reporting
+// unknown sessions, sessions of other tenants as unknown, and sessions of
+// other keys
+func lookupSession(w http.ResponseWriter, r *http.Request, id string) (*session.Session, bool) {
+	sess, err := tenantSessions(r).GetSession(id)
+	if !sendSessionError(w, id, err) {
+		return nil, false
+	}
+	if !authorizeSession(w, r, sess) {
+		return nil, false
+	}
+	return sess, true
+}
+
+// executionLimits narrows the server execution limits to those granted to
+// the caller of r
+func executionLimits(r *http.Request, timeout time.Duration) session.ExecuteOptions {
+	opts := session.ExecuteOptions{Timeout: timeout}
+	principal, _ := auth.PrincipalFrom(r.Context())
+	if principal.MaxTimeout > 0 && (opts.Timeout == 0 || principal.MaxTimeout < opts.Timeout) {
+		opts.Timeout = principal.MaxTimeout
+	}
+	opts.MemoryLimit = principal.MemoryLimit
+	return opts
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// executeAs sends code to the execute endpoint with the given API key
+func executeAs(t *testing.T, server *httptest.Server, key, code, sessionID string) (*models.ResponsePayload, int) {
+	body, _ := json.Marshal(models.RequestPayload{ID: sessionID, Code: code})
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/execute", bytes.NewReader(body))
+	if key != "" {
+		req.Header.Set(auth.APIKeyHeader, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response models.ResponsePayload
+	json.NewDecoder(resp.Body).Decode(&response)
+	return &response, resp.StatusCode
+}
+
+func TestAPIKeySessionOwnership(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(file, []byte(fmt.Sprintf(`{"keys": [{"id": "alice", "sha256": %q}, {"id": "bob", "sha256": %q}]}`,
+		auth.HashSecret("alice-secret"), auth.HashSecret("bob-secret"))), 0644)
+
+	cfg := config.Default()
+	cfg.APIKeysFile = file
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	defer func() {
+		Configure(config.Default())
+		currentConfig = nil
+	}()
+
+	// Requests without a valid key are refused
+	for _, key := range []string{"", "alice-secret-typo"} {
+		if _, status := executeAs(t, server, key, "print(1)", ""); status != http.StatusUnauthorized {
+			t.Fatalf("Expected status code 401 for key %q, got %d", key, status)
+		}
+	}
+
+	response, status := executeAs(t, server, "alice-secret", "x = 1", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", status, response.Error)
+	}
+	id := response.ID
+
+	// Another key can neither execute in nor inspect the session
+	if _, status := executeAs(t, server, "bob-secret", "print(x)", id); status != http.StatusForbidden {
+		t.Fatalf("Expected status code 403, got %d", status)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sessions/"+id+"/history", nil)
+	req.Header.Set(auth.APIKeyHeader, "bob-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status code 403, got %d", resp.StatusCode)
+	}
+
+	// The owner continues the session
+	response, status = executeAs(t, server, "alice-secret", "print(x)", id)
+	if status != http.StatusOK || response.Stdout != "1\n" {
+		t.Fatalf("Expected owner to read the session, got %d %+v", status, response)
+	}
+}
+
+// executeWithToken sends a request to the execute endpoint with a JWT
+func executeWithToken(t *testing.T, server *httptest.Server, token string, payload models.RequestPayload) (*models.ResponsePayload, int) {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/execute", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response models.ResponsePayload
+	json.NewDecoder(resp.Body).Decode(&response)
+	return &response, resp.StatusCode
+}
+
+func TestJWTClaimLimits(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	secret := []byte("portal-secret")
+	file := filepath.Join(t.TempDir(), "jwt.key")
+	os.WriteFile(file, secret, 0600)
+
+	cfg := config.Default()
+	cfg.JWTHMACKeyFile = file
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	defer func() {
+		Configure(config.Default())
+		currentConfig = nil
+	}()
+
+	token := func(claims auth.Claims) string {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return signed
+	}
+	limited := token(auth.Claims{Tenant: "acme", Runtimes: []string{PythonRuntime}, MaxTimeout: 0.5, MemoryLimit: 256 << 20})
+
+	response, status := executeWithToken(t, server, limited, models.RequestPayload{Code: "print('hi')"})
+	if status != http.StatusOK || response.Stdout != "hi\n" {
+		t.Fatalf("Expected execution to succeed, got %d %+v", status, response)
+	}
+
+	// The token's timeout is shorter than the server's
+	response, status = executeWithToken(t, server, limited, models.RequestPayload{Code: "import time\ntime.sleep(1)"})
+	if status != http.StatusGatewayTimeout || response.Error == nil || response.Error.Code != models.CodeTimeout {
+		t.Fatalf("Expected execution timeout, got %+v", response)
+	}
+
+	// Replaying a session is bound by the token's timeout as well
+	response, _ = executeWithToken(t, server, limited, models.RequestPayload{Code: "import time\ntime.sleep(0.3)"})
+	id := response.ID
+	if _, status := executeWithToken(t, server, limited, models.RequestPayload{ID: id, Code: "import time\ntime.sleep(0.3)"}); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/sessions/"+id+"/replay", nil)
+	req.Header.Set("Authorization", "Bearer "+limited)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var replayError models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&replayError)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout || replayError.Error == nil || replayError.Error.Code != models.CodeTimeout {
+		t.Fatalf("Expected replay timeout, got %d %+v", resp.StatusCode, replayError)
+	}
+
+	// The token's memory limit is enforced in the interpreter
+	response, _ = executeWithToken(t, server, limited, models.RequestPayload{Code: "data = bytearray(512 * 1024 * 1024)"})
+	if !strings.Contains(response.Stderr, "MemoryError") {
+		t.Fatalf("Expected MemoryError, got %+v", response)
+	}
+
+	// Runtimes outside the token are refused
+	other := token(auth.Claims{Tenant: "acme", Runtimes: []string{"node"}})
+	if _, status := executeWithToken(t, server, other, models.RequestPayload{Code: "print(1)"}); status != http.StatusForbidden {
+		t.Fatalf("Expected status code 403, got %d", status)
+	}
+	// So are priorities above the token's
+	if _, status := executeWithToken(t, server, limited, models.RequestPayload{Code: "print(1)", Priority: "interactive"}); status != http.StatusForbidden {
+		t.Fatalf("Expected status code 403, got %d", status)
+	}
+	console := token(auth.Claims{Tenant: "acme", MaxPriority: "interactive"})
+	if _, status := executeWithToken(t, server, console, models.RequestPayload{Code: "print(1)", Priority: "interactive"}); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	// Tenants named after domains are served like any other
+	domain := token(auth.Claims{Tenant: "acme.com"})
+	if response, status := executeWithToken(t, server, domain, models.RequestPayload{Code: "print(1)"}); status != http.StatusOK || response.Stdout != "1\n" {
+		t.Fatalf("Expected execution to succeed, got %d %+v", status, response)
+	}
+	if _, status := executeWithToken(t, server, "not-a-token", models.RequestPayload{Code: "print(1)"}); status != http.StatusUnauthorized {
+		t.Fatalf("Expected status code 401, got %d", status)
+	}
+}
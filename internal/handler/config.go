@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"go--python-executor/internal/audit"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/executor"
+	"go--python-executor/internal/logging"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// BaseDir is the directory holding the session directories; empty uses
+	// the session package default
+	BaseDir = ""
+	// PythonPath is the interpreter sessions run with
+	PythonPath = "python3"
+	// AdminToken is the bearer token of the admin endpoints; empty disables
+	// them
+	AdminToken = ""
+)
+
+var (
+	// settingsMutex guards the settings a reload can change
+	settingsMutex sync.RWMutex
+	// currentConfig is the configuration the server runs with, if it was
+	// configured from one
+	currentConfig *config.Config
+	// authenticator checks API keys and JWTs; nil disables authentication
+	authenticator *auth.Authenticator
+	// auditLog records every execution; nil disables auditing
+	auditLog *audit.Log
+	// trustedProxies are the reverse proxies whose forwarding headers name
+	// the client
+	trustedProxies []netip.Prefix
+)
+
+// snapshot is a copy of the reloadable settings read by request handlers
+type snapshot struct {
+	ExecutionTimeout   time.Duration
+	SessionTimeLimit   time.Duration
+	CleanupInterval    time.Duration
+	ReplayTimeout      time.Duration
+	InstallTimeout     time.Duration
+	MaxIdleTimeout     time.Duration
+	MaxSessionLifetime time.Duration
+	RetryAfter         time.Duration
+	SessionDiskQuota   int64
+	MaxUploadSize      int64
+	StrictSessions     bool
+	AdminToken         string
+	ShutdownSessions   string
+	Authenticator      *auth.Authenticator
+	AuditLog           *audit.Log
+	TrustedProxies     []netip.Prefix
+}
+
+// settings returns the current reloadable settings
+func settings() snapshot {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return snapshot{
+		ExecutionTimeout:   ExecutionTimeout,
+		SessionTimeLimit:   SessionTimeLimit,
+		CleanupInterval:    CleanupInterval,
+		ReplayTimeout:      ReplayTimeout,
+		InstallTimeout:     InstallTimeout,
+		MaxIdleTimeout:     MaxIdleTimeout,
+		MaxSessionLifetime: MaxSessionLifetime,
+		RetryAfter:         RetryAfter,
+		SessionDiskQuota:   SessionDiskQuota,
+		MaxUploadSize:      MaxUploadSize,
+		StrictSessions:     StrictSessions,
+		AdminToken:         AdminToken,
+		ShutdownSessions:   ShutdownSessions,
+		Authenticator:      authenticator,
+		AuditLog:           auditLog,
+		TrustedProxies:     trustedProxies,
+	}
+}
+
+// Configure applies the server configuration to the handler settings. It
+// must be called before the first request is served.
+func Configure(cfg *config.Config) error {
+	authn, err := loadAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	BaseDir = cfg.BaseDir
+	PythonPath = cfg.PythonPath
+	SessionDBPath = cfg.SessionDB
+	setReloadable(cfg)
+	currentConfig = cfg
+	authenticator = authn
+	return nil
+}
+
+// loadAuthenticator reads the API key file and JWT verification keys, or
+// returns nil when neither is configured and authentication is disabled
+func loadAuthenticator(cfg *config.Config) (*auth.Authenticator, error) {
+	authn := &auth.Authenticator{}
+	if cfg.APIKeysFile != "" {
+		keys, err := auth.LoadKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authn.Keys = keys
+	}
+	if cfg.JWTHMACKeyFile != "" || cfg.JWTRSAPublicKeyFile != "" {
+		verifier, err := auth.NewJWTVerifier(auth.JWTOptions{
+			HMACKeyFile:      cfg.JWTHMACKeyFile,
+			RSAPublicKeyFile: cfg.JWTRSAPublicKeyFile,
+			Issuer:           cfg.JWTIssuer,
+			Audience:         cfg.JWTAudience,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authn.JWT = verifier
+	}
+	if authn.Keys == nil && authn.JWT == nil {
+		return nil, nil
+	}
+	return authn, nil
+}
+
+// setReloadable sets the handler settings a reload may change. Callers
+// must hold settingsMutex.
+func setReloadable(cfg *config.Config) {
+	AdminToken = cfg.AdminToken
+	// The list was checked when the configuration was loaded
+	trustedProxies, _ = config.ParseTrustedProxies(cfg.TrustedProxies)
+
+	ExecutionTimeout = time.Duration(cfg.ExecutionTimeout)
+	SessionTimeLimit = time.Duration(cfg.SessionTimeLimit)
+	CleanupInterval = time.Duration(cfg.CleanupInterval)
+	ReplayTimeout = time.Duration(cfg.ReplayTimeout)
+	InstallTimeout = time.Duration(cfg.InstallTimeout)
+	MaxIdleTimeout = time.Duration(cfg.MaxIdleTimeout)
+	MaxSessionLifetime = time.Duration(cfg.MaxSessionLifetime)
+
+	MaxConcurrentExecutions = cfg.MaxConcurrent
+	MaxQueuedExecutions = cfg.MaxQueue
+	QueueTimeout = time.Duration(cfg.QueueTimeout)
+	RetryAfter = time.Duration(cfg.RetryAfter)
+	InteractiveReserve = cfg.InteractiveReserve
+	SessionDiskQuota = cfg.SessionDiskQuota
+	GlobalDiskQuota = cfg.GlobalDiskQuota
+	DiskQuotaPolicy = cfg.DiskQuotaPolicy
+	MaxUploadSize = cfg.MaxUploadSize
+
+	StrictSessions = cfg.StrictSessions
+	Wheelhouse = cfg.Wheelhouse
+	WarmPoolSize = cfg.WarmPoolSize
+	WarmPoolPreload = cfg.WarmPoolPreload
+	ShutdownSessions = cfg.ShutdownSessions
+
+	RateLimit = cfg.RateLimit
+	RateLimitBurst = cfg.RateLimitBurst
+	DailyCPUSeconds = cfg.DailyCPUSeconds
+	MaxAccountSessions = cfg.MaxAccountSessions
+	MaxTenantSessions = cfg.MaxTenantSessions
+}
+
+// ReloadConfig reads the configuration sources again and applies the
+// settings that are safe to change while running: timeouts, limits, quotas
+// and policies take effect for new executions, while running executions and
+// existing sessions are left alone. Every change is logged and returned.
+func ReloadConfig() ([]config.Change, error) {
+	settingsMutex.RLock()
+	current := currentConfig
+	settingsMutex.RUnlock()
+	if current == nil {
+		return nil, errors.New("server was not started from a configuration")
+	}
+
+	next, err := current.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	// Key files are read again even if their paths are unchanged, so keys
+	// can be added, rotated and revoked without a restart
+	authn, err := loadAuthenticator(next)
+	if err != nil {
+		return nil, err
+	}
+	settingsMutex.Lock()
+	authenticator = authn
+	settingsMutex.Unlock()
+	return applyConfig(next), nil
+}
+
+// applyConfig switches to next and reconfigures the session manager and
+// executor where the relevant settings changed
+func applyConfig(next *config.Config) []config.Change {
+	settingsMutex.Lock()
+	merged, changes := config.Apply(currentConfig, next)
+	setReloadable(merged)
+	currentConfig = merged
+	settingsMutex.Unlock()
+
+	manager := getSessionManager()
+	for _, change := range changes {
+		if !change.Applied {
+			slog.Warn("Config reload: setting requires a restart", "setting", change.Setting, "old", change.Old, "new", change.New)
+			continue
+		}
+		slog.Info("Config reload: setting changed", "setting", change.Setting, "old", change.Old, "new", change.New)
+
+		switch change.Setting {
+		case "log_level":
+			logging.SetLevel(merged.LogLevel)
+		case "wheelhouse":
+			manager.SetWheelhouse(merged.Wheelhouse)
+		case "session_disk_quota", "global_disk_quota", "disk_quota_policy":
+			manager.SetDiskQuota(session.DiskQuota{
+				Session: merged.SessionDiskQuota,
+				Global:  merged.GlobalDiskQuota,
+				Policy:  session.QuotaPolicy(merged.DiskQuotaPolicy),
+			})
+		case "warm_pool_size", "warm_pool_preload":
+			manager.ConfigurePool(session.PoolOptions{Size: merged.WarmPoolSize, Preload: merged.WarmPoolPreload})
+		case "max_tenant_sessions":
+			manager.SetTenantSessionLimit(merged.MaxTenantSessions)
+		case "rate_limit", "rate_limit_burst", "daily_cpu_seconds", "max_account_sessions":
+			settingsMutex.RLock()
+			accountLimiter.SetOptions(accountLimits())
+			settingsMutex.RUnlock()
+		case "max_concurrent", "max_queue", "queue_timeout", "interactive_reserve":
+			executionLimiter.SetOptions(executor.Options{
+				MaxConcurrent:      merged.MaxConcurrent,
+				MaxQueue:           merged.MaxQueue,
+				QueueTimeout:       time.Duration(merged.QueueTimeout),
+				InteractiveReserve: merged.InteractiveReserve,
+			})
+		}
+	}
+	if len(changes) == 0 {
+		slog.Info("Config reload: no settings changed")
+	}
+	return changes
+}
+
+// requireAdmin checks the admin bearer token and reports whether the
+// request may proceed
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := settings().AdminToken
+	if token == "" {
+		sendErrorResponse(w, http.StatusNotFound, models.CodeInvalidRequest, "", "admin endpoints are disabled")
+		return false
+	}
+
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		sendErrorResponse(w, http.StatusUnauthorized, models.CodePolicyViolation, "", "missing or invalid admin token")
+		return false
+	}
+	return true
+}
+
+// ReloadHandler reloads the server configuration on behalf of an admin
+func ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	changes, err := ReloadConfig()
+	if err != nil {
+		sendErrorResponse(w, http.StatusUnprocessableEntity, models.CodeInvalidRequest, "", err.Error())
+		return
+	}
+
+	response := models.ReloadResponse{Changes: []models.ConfigChange{}}
+	for _, change := range changes {
+		response.Changes = append(response.Changes, models.ConfigChange(change))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reload sends a reload request with the given bearer token
+func reload(t *testing.T, url, token string) *http.Response {
+	req, _ := http.NewRequest(http.MethodPost, url+"/admin/reload", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	return resp
+}
+
+func TestReloadConfig(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(file, []byte(`{"admin_token": "secret", "execution_timeout": "3s"}`), 0644)
+
+	cfg, _, err := config.Load([]string{"-config", file}, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	getSessionManager()
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	defer func() {
+		Configure(config.Default())
+		applyConfig(config.Default())
+		currentConfig = nil
+	}()
+
+	if settings().ExecutionTimeout != 3*time.Second {
+		t.Fatalf("Expected execution timeout of 3s, got %v", settings().ExecutionTimeout)
+	}
+
+	// The endpoint requires the admin token
+	resp := reload(t, server.URL, "wrong")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status code 401, got %d", resp.StatusCode)
+	}
+
+	os.WriteFile(file, []byte(`{"admin_token": "secret", "execution_timeout": "4s", "max_queue": 5, "listen": ":9999"}`), 0644)
+	resp = reload(t, server.URL, "secret")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	var response models.ReloadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+	applied := map[string]bool{}
+	for _, change := range response.Changes {
+		applied[change.Setting] = change.Applied
+	}
+	if len(applied) != 3 || !applied["execution_timeout"] || !applied["max_queue"] || applied["listen"] {
+		t.Fatalf("Unexpected changes: %+v", response.Changes)
+	}
+
+	// Safe settings apply to new executions, the listen address does not
+	if settings().ExecutionTimeout != 4*time.Second {
+		t.Fatalf("Expected execution timeout of 4s, got %v", settings().ExecutionTimeout)
+	}
+	if executionLimiter.Stats().MaxQueue != 5 {
+		t.Fatalf("Expected a queue of 5, got %d", executionLimiter.Stats().MaxQueue)
+	}
+	if currentConfig.Listen != ":8080" {
+		t.Fatalf("Expected listen address to stay :8080, got %s", currentConfig.Listen)
+	}
+
+	// An invalid file is rejected and changes nothing
+	os.WriteFile(file, []byte(`{"admin_token": "secret", "execution_timeout": "0s"}`), 0644)
+	resp = reload(t, server.URL, "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status code 422, got %d", resp.StatusCode)
+	}
+	if settings().ExecutionTimeout != 4*time.Second {
+		t.Fatalf("Expected execution timeout to stay 4s, got %v", settings().ExecutionTimeout)
+	}
+}
+
+func TestAdminEndpointsDisabledWithoutToken(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	resp := reload(t, server.URL, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404, got %d", resp.StatusCode)
+	}
+}
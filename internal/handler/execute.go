@@ -3,97 +3,423 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/executor"
+	"go--python-executor/internal/logging"
+	"go--python-executor/internal/metrics"
 	"go--python-executor/internal/models"
+	"go--python-executor/internal/ratelimit"
 	"go--python-executor/internal/session"
+	"log/slog"
 	"net/http"
+	"os/exec"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
 	ExecutionTimeout = 2 * time.Second  // 2-second execution limit
 	SessionTimeLimit = 5 * time.Minute  // 5-minute session lifetime
 	CleanupInterval  = 30 * time.Second // Cleanup old sessions every minute
+	ReplayTimeout    = 30 * time.Second // Limit for replaying a whole session history
+
+	MaxIdleTimeout     = 1 * time.Hour  // Ceiling for client-requested idle timeouts
+	MaxSessionLifetime = 24 * time.Hour // Ceiling and default for the absolute session lifetime
+
+	// StrictSessions rejects unknown session IDs on /execute instead of
+	// creating a session with the client-chosen ID
+	StrictSessions = false
+
+	// SessionDiskQuota and GlobalDiskQuota limit disk usage in bytes per
+	// session and for all sessions together; zero means unlimited.
+	// DiskQuotaPolicy is "reject" or "evict" and applies to the global quota.
+	SessionDiskQuota int64 = 0
+	GlobalDiskQuota  int64 = 0
+	DiskQuotaPolicy        = string(session.QuotaReject)
+
+	// Wheelhouse is the local directory of wheels sessions may install
+	// packages from; empty disables installation
+	Wheelhouse     = ""
+	InstallTimeout = 5 * time.Minute // Limit for creating a venv and installing packages
+
+	// WarmPoolSize is the number of interpreters kept started ahead of time,
+	// each having run the WarmPoolPreload script; zero disables the pool
+	WarmPoolSize    = 0
+	WarmPoolPreload = ""
+
+	// MaxConcurrentExecutions bounds the interpreters running at once; zero
+	// means unlimited. Excess executions wait in a queue of up to
+	// MaxQueuedExecutions for at most QueueTimeout, and are refused with
+	// 429 Too Many Requests and a Retry-After of RetryAfter otherwise.
+	MaxConcurrentExecutions = runtime.NumCPU()
+	MaxQueuedExecutions     = 100
+	QueueTimeout            = 10 * time.Second
+	RetryAfter              = 1 * time.Second
+
+	// InteractiveReserve is the fraction of MaxConcurrentExecutions only
+	// interactive executions may use
+	InteractiveReserve = 0.0
+
+	// ShutdownSessions is "persist" to keep sessions for the next start or
+	// "cleanup" to remove them when the server shuts down
+	ShutdownSessions = "persist"
+
+	// RateLimit and RateLimitBurst size the token bucket of every API key
+	// or tenant, DailyCPUSeconds bounds the CPU time its executions use per
+	// UTC day and MaxAccountSessions the sessions it holds; zero disables
+	// a limit
+	RateLimit          = 0.0
+	RateLimitBurst     = 0
+	DailyCPUSeconds    = 0.0
+	MaxAccountSessions = 0
+
+	// MaxTenantSessions caps the sessions of every tenant in the session
+	// manager; zero disables the limit
+	MaxTenantSessions = 0
 )
 
+// PythonRuntime names the interpreter executions run with, which callers
+// may be restricted to or from
+const PythonRuntime = "python"
+
+// SessionDBPath selects an embedded bbolt database for session metadata and
+// state instead of files in the session directories when non-empty
+var SessionDBPath = ""
+
 var (
-	sessionManager *session.Manager
-	once           sync.Once
+	sessionManager   *session.Manager
+	executionLimiter *executor.Limiter
+	accountLimiter   *ratelimit.Limiter
+	once             sync.Once
+
+	// stopCleanup ends the cleanup loop, which closes cleanupDone on exit
+	stopCleanup context.CancelFunc
+	cleanupDone = make(chan struct{})
 )
 
 // getSessionManager returns the singleton session manager
 func getSessionManager() *session.Manager {
 	once.Do(func() {
-		sessionManager = session.NewManager()
+		sessionManager = newSessionManager()
 
-		// Start a goroutine to clean up old sessions
+		// Pick up sessions that survived a restart and drop expired leftovers
+		sessionManager.RestoreSessions(settings().SessionTimeLimit)
+
+		// Start a goroutine to clean up old sessions until shutdown
+		var ctx context.Context
+		ctx, stopCleanup = context.WithCancel(context.Background())
 		go func() {
-			for {
-				time.Sleep(CleanupInterval)
-				sessionManager.CleanupSessions(SessionTimeLimit)
-			}
+			defer close(cleanupDone)
+			cleanupLoop(ctx, sessionManager)
 		}()
 	})
 	return sessionManager
 }
 
-// Helper function to send error responses
-func sendErrorResponse(w http.ResponseWriter, sessionID, message string) {
+// cleanupLoop removes expired sessions and forgets idle accounts every
+// CleanupInterval until ctx is done
+func cleanupLoop(ctx context.Context, manager *session.Manager) {
+	for {
+		timer := time.NewTimer(settings().CleanupInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			manager.CleanupSessions(settings().SessionTimeLimit)
+			accountLimiter.Prune()
+		}
+	}
+}
+
+// newSessionManager builds the session manager with the configured store
+// and quota, falling back to the default file store if the database cannot
+// be opened
+func newSessionManager() *session.Manager {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+
+	opts := session.Options{BaseDir: BaseDir, PythonPath: PythonPath}
+	if SessionDBPath != "" {
+		if store, err := session.NewBoltStore(SessionDBPath); err != nil {
+			slog.Warn("Using file session store", "error", err)
+		} else {
+			opts.Store = store
+		}
+	}
+
+	manager, err := session.NewManagerWithOptions(opts)
+	if err != nil && opts.Store != nil {
+		slog.Warn("Using file session store", "error", err)
+		opts.Store.Close()
+		opts.Store = nil
+		manager, err = session.NewManagerWithOptions(opts)
+	}
+	if err != nil {
+		slog.Warn("Using default session directory", "error", err)
+		manager = session.NewManager()
+	}
+
+	manager.SetWheelhouse(Wheelhouse)
+	manager.SetDiskQuota(session.DiskQuota{
+		Session: SessionDiskQuota,
+		Global:  GlobalDiskQuota,
+		Policy:  session.QuotaPolicy(DiskQuotaPolicy),
+	})
+	manager.ConfigurePool(session.PoolOptions{Size: WarmPoolSize, Preload: WarmPoolPreload})
+	manager.SetTenantSessionLimit(MaxTenantSessions)
+
+	executionLimiter = executor.New(executor.Options{
+		MaxConcurrent:      MaxConcurrentExecutions,
+		MaxQueue:           MaxQueuedExecutions,
+		QueueTimeout:       QueueTimeout,
+		InteractiveReserve: InteractiveReserve,
+	})
+	manager.SetLimiter(metrics.TimeLimiter(executionLimiter))
+
+	// Sessions count against their account's quota until they are gone
+	accountLimiter = ratelimit.New(accountLimits(), manager.HasSession)
+
+	// Export session and execution metrics
+	manager.SetExecutionObserver(metrics.ObserveExecution)
+	metrics.RegisterServer(manager, executionLimiter)
+	return manager
+}
+
+// sessionLifetime converts the requested session lifetime into durations
+// bounded by the server ceilings
+func sessionLifetime(idleSeconds, maxSeconds int) (session.Lifetime, error) {
+	if idleSeconds < 0 || maxSeconds < 0 {
+		return session.Lifetime{}, errors.New("idle_timeout and max_lifetime must not be negative")
+	}
+
+	lifetime := session.Lifetime{
+		IdleTimeout: time.Duration(idleSeconds) * time.Second,
+		MaxLifetime: time.Duration(maxSeconds) * time.Second,
+	}
+
+	current := settings()
+	if current.MaxIdleTimeout > 0 && lifetime.IdleTimeout > current.MaxIdleTimeout {
+		lifetime.IdleTimeout = current.MaxIdleTimeout
+	}
+	if current.MaxSessionLifetime > 0 && (lifetime.MaxLifetime == 0 || lifetime.MaxLifetime > current.MaxSessionLifetime) {
+		lifetime.MaxLifetime = current.MaxSessionLifetime
+	}
+	return lifetime, nil
+}
+
+// sendSessionError reports a failed session lookup and returns whether the
+// lookup succeeded
+func sendSessionError(w http.ResponseWriter, sessionID string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, session.ErrInvalidSessionID):
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", err.Error())
+	case errors.Is(err, session.ErrSessionNotFound):
+		sendErrorResponse(w, http.StatusNotFound, models.CodeSessionNotFound, sessionID, err.Error())
+	case errors.Is(err, session.ErrTenantSessionLimit):
+		sendQuotaError(w, sessionID, err)
+	default:
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, "", "failed to initialize session: "+err.Error())
+	}
+	return false
+}
+
+// sendBusyError reports an execution refused by admission control and
+// returns whether it was
+func sendBusyError(w http.ResponseWriter, sessionID string, err error) bool {
+	if !errors.Is(err, executor.ErrQueueFull) && !errors.Is(err, executor.ErrQueueTimeout) {
+		return false
+	}
+	seconds := int((settings().RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	sendErrorResponse(w, http.StatusTooManyRequests, models.CodeLimitExceeded, sessionID, err.Error())
+	return true
+}
+
+// sendErrorResponse sends the error object with the given status and code
+func sendErrorResponse(w http.ResponseWriter, status int, code models.ErrorCode, sessionID, message string) {
 	w.Header().Set("Content-Type", "application/json")
-	response := models.ResponsePayload{
+	w.WriteHeader(status)
+	response := models.ErrorResponse{
 		ID:    sessionID,
-		Error: message,
+		Error: &models.Error{Code: code, Message: message},
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// logExecution logs the outcome and duration of an execution, including
+// the time it waited for the session and an interpreter slot
+func logExecution(logger *slog.Logger, outcome string, duration time.Duration, err error) {
+	args := []any{"outcome", outcome, "duration_ms", duration.Milliseconds()}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	logger.Info("Execution finished", args...)
+}
+
 // ExecuteHandler processes Python code execution requests
 func ExecuteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		sendErrorResponse(w, http.StatusMethodNotAllowed, models.CodeInvalidRequest, "", "invalid request method")
 		return
 	}
 
 	var req models.RequestPayload
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", "invalid request payload")
 		return
 	}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), ExecutionTimeout)
-	defer cancel()
+	lifetime, err := sessionLifetime(req.IdleTimeout, req.MaxLifetime)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, req.ID, err.Error())
+		return
+	}
+	opts := session.CreateOptions{Lifetime: lifetime, Owner: owner(r)}
 
-	// Get or create session
-	manager := getSessionManager()
-	session, err := manager.GetOrCreateSession(req.ID)
+	priority, err := executor.ParsePriority(req.Priority)
 	if err != nil {
-		sendErrorResponse(w, "", "Failed to initialize session")
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, req.ID, err.Error())
+		return
+	}
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok && !principal.AllowsPriority(priority) {
+		sendErrorResponse(w, http.StatusForbidden, models.CodePolicyViolation, req.ID, "priority "+priority.String()+" is not allowed")
 		return
 	}
+	ctx := executor.WithPriority(r.Context(), priority)
 
-	// Execute code in the session
-	stdout, stderr, err := session.ExecuteCode(ctx, req.Code)
+	runtimeName := req.Runtime
+	if runtimeName == "" {
+		runtimeName = PythonRuntime
+	}
+	if runtimeName != PythonRuntime {
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, req.ID, "unknown runtime "+runtimeName)
+		return
+	}
+	if principal, _ := auth.PrincipalFrom(ctx); !principal.AllowsRuntime(runtimeName) {
+		sendErrorResponse(w, http.StatusForbidden, models.CodePolicyViolation, req.ID, "runtime "+runtimeName+" is not allowed")
+		return
+	}
+
+	// Enforce the caller's quotas before a session is looked up or created
+	sessions := tenantSessions(r)
+	current := settings()
+	caller := account(r)
+	if err := accountLimiter.CheckCPU(caller); sendQuotaError(w, req.ID, err) {
+		metrics.LimitExceeded()
+		return
+	}
+	_, lookupErr := sessions.GetSession(req.ID)
+	creates := req.ID == "" || (!current.StrictSessions && lookupErr != nil)
+	commit := func(string) {}
+	if creates {
+		var ok bool
+		if commit, ok = reserveSession(w, r); !ok {
+			metrics.LimitExceeded()
+			return
+		}
+	}
+
+	// Get or create session. In strict mode only sessions minted by the
+	// server can be continued, so an unknown ID is reported instead of
+	// silently starting over with empty state.
+	var sess *session.Session
+	_, span := tracer().Start(ctx, "session.lookup")
+	if current.StrictSessions && req.ID != "" {
+		sess, err = sessions.GetSession(req.ID)
+	} else {
+		sess, err = sessions.GetOrCreateSessionWithOptions(req.ID, opts)
+	}
+	if err == nil && creates {
+		commit(sess.Key())
+	} else {
+		commit("")
+	}
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(attribute.String("pyexec.session_id", sess.ID))
+	}
+	span.End()
+	logger := logging.FromContext(ctx)
+	if err != nil && !errors.Is(err, session.ErrInvalidSessionID) && !errors.Is(err, session.ErrSessionNotFound) && !errors.Is(err, session.ErrTenantSessionLimit) {
+		logger.Error("Failed to initialize session", "session_id", req.ID, "error", err)
+	}
+	if !sendSessionError(w, req.ID, err) || !authorizeSession(w, r, sess) {
+		return
+	}
+	logger = logger.With("session_id", sess.ID)
+
+	// Execute code in the session within the limits granted to the caller.
+	// The execution timeout starts once the session is free and an
+	// interpreter slot is granted.
+	start := time.Now()
+	result, err := sess.Execute(ctx, req.Code, executionLimits(r, current.ExecutionTimeout))
+	duration := time.Since(start)
+	stdout, stderr := result.Stdout, result.Stderr
+	accountLimiter.AddCPU(caller, result.Usage.CPUTime)
+
+	// finish logs the outcome of the execution and records it in the
+	// audit log
+	finish := func(outcome string, err error) {
+		logExecution(logger, outcome, duration, err)
+		auditExecution(r, sess.ID, req.Code, outcome, result.Usage, duration, err)
+	}
+
+	if errors.Is(err, session.ErrQuotaExceeded) {
+		metrics.LimitExceeded()
+		finish(metrics.OutcomeLimitExceeded, err)
+		sendErrorResponse(w, http.StatusInsufficientStorage, models.CodeLimitExceeded, sess.ID, err.Error())
+		return
+	}
+	if sendBusyError(w, sess.ID, err) {
+		metrics.LimitExceeded()
+		finish(metrics.OutcomeLimitExceeded, err)
+		return
+	}
 
 	// Check for timeout
-	if ctx.Err() == context.DeadlineExceeded {
-		sendErrorResponse(w, session.ID, "execution timeout")
+	if errors.Is(err, context.DeadlineExceeded) {
+		finish(metrics.OutcomeTimeout, err)
+		sendErrorResponse(w, http.StatusGatewayTimeout, models.CodeTimeout, sess.ID, "execution timeout")
+		return
+	}
+
+	// Without a Python traceback or an exit status of the interpreter, the
+	// execution could not be run at all
+	var exitErr *exec.ExitError
+	exited := errors.As(err, &exitErr)
+	if err != nil && stderr == "" && !exited {
+		logger.Error("Execution failed", "duration_ms", duration.Milliseconds(), "error", err)
+		auditExecution(r, sess.ID, req.Code, metrics.OutcomeError, result.Usage, duration, err)
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, sess.ID, err.Error())
 		return
 	}
 
 	// Prepare response
 	response := models.ResponsePayload{
-		ID:     session.ID,
-		Stdout: stdout,
-		Stderr: stderr,
+		ID:           sess.ID,
+		Stdout:       stdout,
+		Stderr:       stderr,
+		ExpiresAt:    sess.ExpiresAt(current.SessionTimeLimit).UTC().Format(time.RFC3339),
+		DiskUsage:    sess.DiskUsage(),
+		Unrestorable: result.Unrestorable,
 	}
-
-	// Handle errors
-	if err != nil && stderr == "" {
-		response.Error = err.Error()
-		response.Stdout = ""
-		response.Stderr = ""
+	if exited {
+		response.ExitCode = exitErr.ExitCode()
+	}
+	if err != nil {
+		finish(metrics.OutcomeError, nil)
+	} else {
+		finish(metrics.OutcomeOK, nil)
 	}
 
 	// Send response
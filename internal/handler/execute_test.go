@@ -4,20 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/executor"
 	"go--python-executor/internal/models"
 	"go--python-executor/internal/session"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 )
 
-// setupTestServer creates a test HTTP server with the execute handler
+// setupTestServer creates a test HTTP server with the API handlers
 func setupTestServer() *httptest.Server {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/execute", ExecuteHandler)
-	return httptest.NewServer(mux)
+	RegisterRoutes(mux)
+	return httptest.NewServer(RouteErrors(mux))
 }
 
 // executeCode is a helper function that sends code to the execute endpoint
@@ -69,8 +74,8 @@ func TestBasicExecution(t *testing.T) {
 		t.Fatalf("Expected empty stderr, got '%s'", response.Stderr)
 	}
 
-	if response.Error != "" {
-		t.Fatalf("Expected no error, got '%s'", response.Error)
+	if response.Error != nil {
+		t.Fatalf("Expected no error, got %+v", response.Error)
 	}
 
 	// Store the session ID for future tests
@@ -134,6 +139,27 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestExitCode(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	// A failing exit without output still tells the caller what happened
+	response, resp := executeCode(t, server, "import sys\nsys.exit(3)", "")
+	if resp.StatusCode != http.StatusOK || response.Stderr != "" || response.ExitCode != 3 {
+		t.Fatalf("Expected exit code 3 with status 200, got %d %+v", resp.StatusCode, response)
+	}
+
+	response, _ = executeCode(t, server, "print(undefined_variable)", response.ID)
+	if response.ExitCode != 1 {
+		t.Fatalf("Expected exit code 1 after an exception, got %d", response.ExitCode)
+	}
+
+	response, _ = executeCode(t, server, "print('ok')", response.ID)
+	if response.ExitCode != 0 || response.Stdout != "ok\n" {
+		t.Fatalf("Expected a successful execution, got %+v", response)
+	}
+}
+
 func TestExecutionTimeout(t *testing.T) {
 	// Override the execution timeout for testing
 	originalTimeout := ExecutionTimeout
@@ -144,10 +170,13 @@ func TestExecutionTimeout(t *testing.T) {
 	defer server.Close()
 
 	// Test code that should timeout
-	response, _ := executeCode(t, server, "import time; time.sleep(1)", "")
+	response, resp := executeCode(t, server, "import time; time.sleep(1)", "")
 
-	if !strings.Contains(response.Error, "timeout") && !strings.Contains(response.Error, "deadline") {
-		t.Fatalf("Expected timeout error, got: '%s'", response.Error)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status code 504, got %d", resp.StatusCode)
+	}
+	if response.Error == nil || response.Error.Code != models.CodeTimeout {
+		t.Fatalf("Expected timeout error, got %+v", response.Error)
 	}
 
 	// Verify the session still works after timeout
@@ -273,3 +302,268 @@ func TestConcurrentRequests(t *testing.T) {
 		t.Fatalf("Expected counter to be '%s', got '%s'", expected, response.Stdout)
 	}
 }
+
+func TestSessionLifetime(t *testing.T) {
+	originalMaxIdle := MaxIdleTimeout
+	MaxIdleTimeout = 10 * time.Minute
+	defer func() { MaxIdleTimeout = originalMaxIdle }()
+
+	server := setupTestServer()
+	defer server.Close()
+
+	payload, _ := json.Marshal(models.RequestPayload{
+		Code:        "print('hi')",
+		IdleTimeout: 3600,
+		MaxLifetime: 60,
+	})
+	resp, err := http.Post(server.URL+"/execute", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response models.ResponsePayload
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, response.ExpiresAt)
+	if err != nil {
+		t.Fatalf("Expected expires_at timestamp, got '%s'", response.ExpiresAt)
+	}
+
+	// The one minute max lifetime is earlier than the clamped idle timeout
+	if remaining := time.Until(expiresAt); remaining > time.Minute || remaining < 50*time.Second {
+		t.Fatalf("Expected session to expire in about a minute, got %v", remaining)
+	}
+
+	lifetime, err := sessionLifetime(3600, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lifetime.IdleTimeout != MaxIdleTimeout || lifetime.MaxLifetime != MaxSessionLifetime {
+		t.Fatalf("Expected lifetime to be bounded by ceilings, got %+v", lifetime)
+	}
+
+	if _, err := sessionLifetime(-1, 0); err == nil {
+		t.Fatal("Expected error for negative idle timeout")
+	}
+}
+
+func TestAdmissionControl(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	// Allow one execution and no queue
+	getSessionManager()
+	executionLimiter.SetOptions(executor.Options{MaxConcurrent: 1})
+	defer executionLimiter.SetOptions(executor.Options{
+		MaxConcurrent:      MaxConcurrentExecutions,
+		MaxQueue:           MaxQueuedExecutions,
+		QueueTimeout:       QueueTimeout,
+		InteractiveReserve: InteractiveReserve,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		executeCode(t, server, "import time\ntime.sleep(1)", "")
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for executionLimiter.Stats().Running == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the first execution to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	response, resp := executeCode(t, server, "print('refused')", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("Expected a Retry-After header")
+	}
+	if response.Error == nil || response.Error.Code != models.CodeLimitExceeded {
+		t.Fatalf("Expected a limit_exceeded error, got %+v", response.Error)
+	}
+	<-done
+
+	// With a queue the second execution waits for the slot instead
+	executionLimiter.SetOptions(executor.Options{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 5 * time.Second})
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		executeCode(t, server, "import time\ntime.sleep(0.5)", "")
+	}()
+	for executionLimiter.Stats().Running == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	response, resp = executeCode(t, server, "print('queued')", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(response.Stdout, "queued") {
+		t.Fatalf("Expected queued execution to run, got status %d and stdout '%s'", resp.StatusCode, response.Stdout)
+	}
+	<-done
+}
+
+func TestExecutionPriority(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	for _, priority := range []string{"interactive", "batch"} {
+		jsonData, _ := json.Marshal(models.RequestPayload{Code: "print('ok')", Priority: priority})
+		resp, err := http.Post(server.URL+"/execute", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code 200 for priority %s, got %d", priority, resp.StatusCode)
+		}
+	}
+
+	jsonData, _ := json.Marshal(models.RequestPayload{Code: "print('ok')", Priority: "urgent"})
+	resp, err := http.Post(server.URL+"/execute", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400 for an unknown priority, got %d", resp.StatusCode)
+	}
+
+	// With one slot taken, a queued interactive execution is started before
+	// a batch execution queued earlier
+	getSessionManager()
+	executionLimiter.SetOptions(executor.Options{MaxConcurrent: 1, MaxQueue: 10, QueueTimeout: 10 * time.Second})
+	defer executionLimiter.SetOptions(executor.Options{
+		MaxConcurrent:      MaxConcurrentExecutions,
+		MaxQueue:           MaxQueuedExecutions,
+		QueueTimeout:       QueueTimeout,
+		InteractiveReserve: InteractiveReserve,
+	})
+	waitFor := func(running, queued int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for stats := executionLimiter.Stats(); stats.Running != running || stats.Queued != queued; stats = executionLimiter.Stats() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d running and %d queued executions, got %+v", running, queued, stats)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	order := make(chan string, 3)
+	send := func(priority, code string) {
+		jsonData, _ := json.Marshal(models.RequestPayload{Code: code, Priority: priority})
+		resp, err := http.Post(server.URL+"/execute", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			order <- err.Error()
+			return
+		}
+		resp.Body.Close()
+		order <- priority
+	}
+	go send("normal", "import time\ntime.sleep(0.5)")
+	waitFor(1, 0)
+	go send("batch", "print('batch')")
+	waitFor(1, 1)
+	go send("interactive", "print('interactive')")
+	waitFor(1, 2)
+
+	for _, expected := range []string{"normal", "interactive", "batch"} {
+		if got := <-order; got != expected {
+			t.Fatalf("Expected the %s execution to finish next, got %s", expected, got)
+		}
+	}
+}
+
+func TestPriorityLimits(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(file, []byte(fmt.Sprintf(`{"keys": [{"id": "worker", "sha256": %q}, {"id": "console", "sha256": %q, "max_priority": "interactive"}]}`,
+		auth.HashSecret("worker-secret"), auth.HashSecret("console-secret"))), 0644)
+
+	cfg := config.Default()
+	cfg.APIKeysFile = file
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	defer func() {
+		Configure(config.Default())
+		currentConfig = nil
+	}()
+
+	// Keys may only use the priorities granted to them, normal by default
+	for _, tc := range []struct {
+		key      string
+		priority string
+		status   int
+	}{
+		{"worker-secret", "batch", http.StatusOK},
+		{"worker-secret", "normal", http.StatusOK},
+		{"worker-secret", "interactive", http.StatusForbidden},
+		{"console-secret", "interactive", http.StatusOK},
+	} {
+		body, _ := json.Marshal(models.RequestPayload{Code: "print('ok')", Priority: tc.priority})
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/execute", bytes.NewReader(body))
+		req.Header.Set(auth.APIKeyHeader, tc.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var response models.ResponsePayload
+		json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Expected status code %d for %s with priority %s, got %d", tc.status, tc.key, tc.priority, resp.StatusCode)
+		}
+		if tc.status == http.StatusForbidden && (response.Error == nil || response.Error.Code != models.CodePolicyViolation) {
+			t.Fatalf("Expected a policy_violation error, got %+v", response.Error)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   models.ErrorCode
+	}{
+		{http.MethodPost, "/execute", "{not json", http.StatusBadRequest, models.CodeInvalidRequest},
+		{http.MethodGet, "/execute", "", http.StatusMethodNotAllowed, models.CodeInvalidRequest},
+		{http.MethodPost, "/execute", `{"id": "../escape", "code": "print(1)"}`, http.StatusBadRequest, models.CodeInvalidRequest},
+		{http.MethodPost, "/execute", `{"code": "print(1)", "runtime": "ruby"}`, http.StatusBadRequest, models.CodeInvalidRequest},
+		{http.MethodPost, "/execute", `{"code": "print(1)", "idle_timeout": -1}`, http.StatusBadRequest, models.CodeInvalidRequest},
+		// Requests the mux cannot route
+		{http.MethodGet, "/sessions/x/replay", "", http.StatusMethodNotAllowed, models.CodeInvalidRequest},
+		{http.MethodGet, "/no-such-endpoint", "", http.StatusNotFound, models.CodeInvalidRequest},
+	} {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.path, strings.NewReader(tc.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+
+		var response models.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Expected a JSON error for %s %s %q, got %v", tc.method, tc.path, tc.body, err)
+		}
+		if resp.StatusCode != tc.status || response.Error == nil || response.Error.Code != tc.code || response.Error.Message == "" {
+			t.Fatalf("Expected %d %s for %s %s %q, got %d %+v", tc.status, tc.code, tc.method, tc.path, tc.body, resp.StatusCode, response.Error)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("Expected a JSON content type, got %q", contentType)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go--python-executor/internal/logging"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// MaxUploadSize limits the body of a single upload request
+var MaxUploadSize int64 = 32 << 20
+
+// sendFileError reports a failed workspace operation
+func sendFileError(w http.ResponseWriter, sessionID string, err error) {
+	switch {
+	case errors.Is(err, session.ErrSessionNotFound):
+		sendErrorResponse(w, http.StatusNotFound, models.CodeSessionNotFound, sessionID, err.Error())
+	case errors.Is(err, session.ErrInvalidPath):
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, sessionID, err.Error())
+	case errors.Is(err, session.ErrQuotaExceeded):
+		sendErrorResponse(w, http.StatusInsufficientStorage, models.CodeLimitExceeded, sessionID, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		sendErrorResponse(w, http.StatusNotFound, models.CodeInvalidRequest, sessionID, "file not found")
+	default:
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, http.StatusRequestEntityTooLarge, models.CodeLimitExceeded, sessionID, "upload too large")
+			return
+		}
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, sessionID, err.Error())
+	}
+}
+
+// ListFilesHandler lists the files in a session workspace with their sizes
+func ListFilesHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	files, err := sess.ListFiles()
+	if err != nil {
+		sendFileError(w, id, err)
+		return
+	}
+
+	response := models.FileListResponse{ID: sess.ID, Files: make([]models.FileInfo, 0, len(files))}
+	for _, file := range files {
+		response.Files = append(response.Files, models.FileInfo{
+			Path:       file.Path,
+			Size:       file.Size,
+			IsDir:      file.IsDir,
+			ModifiedAt: file.ModifiedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DownloadFileHandler sends a single file from a session workspace
+func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("path")
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+
+	if err := sess.ReadFile(name, w); err != nil {
+		w.Header().Del("Content-Disposition")
+		sendFileError(w, id, err)
+	}
+}
+
+// DownloadArchiveHandler sends the whole session workspace as a zip archive
+func DownloadArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sess.ID+".zip"))
+	out := &trackingWriter{w: w}
+	if err := sess.WriteArchive(out); err != nil {
+		if !out.written {
+			// Nothing was sent yet, so a proper status can still be sent
+			w.Header().Del("Content-Disposition")
+			sendFileError(w, id, err)
+			return
+		}
+		// The client gets a truncated archive that fails to open
+		logging.FromContext(r.Context()).Error("Failed to send workspace archive", "session_id", id, "error", err)
+	}
+}
+
+// trackingWriter records whether anything was written through it
+type trackingWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
+
+// UploadFileHandler stores the raw request body at the given workspace path
+func UploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("path")
+	size, err := sess.WriteFile(name, http.MaxBytesReader(w, r.Body, settings().MaxUploadSize))
+	if err != nil {
+		sendFileError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.FileListResponse{
+		ID:    sess.ID,
+		Files: []models.FileInfo{{Path: path.Clean(name), Size: size, ModifiedAt: time.Now().UTC().Format(time.RFC3339)}},
+	})
+}
+
+// UploadMultipartHandler stores every file of a multipart form in the
+// workspace, under the directory given by the optional dir query parameter
+func UploadMultipartHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, settings().MaxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, id, "expected a multipart/form-data body")
+		return
+	}
+
+	dir := r.URL.Query().Get("dir")
+	response := models.FileListResponse{ID: sess.ID, Files: []models.FileInfo{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sendFileError(w, id, err)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		name := path.Join(dir, path.Base(part.FileName()))
+		size, err := sess.WriteFile(name, part)
+		part.Close()
+		if err != nil {
+			sendFileError(w, id, err)
+			return
+		}
+		response.Files = append(response.Files, models.FileInfo{
+			Path:       name,
+			Size:       size,
+			ModifiedAt: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteFileHandler removes a file or directory from a session workspace
+func DeleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	if err := sess.RemoveFile(r.PathValue("path")); err != nil {
+		sendFileError(w, id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
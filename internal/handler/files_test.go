@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceFileEndpoints(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	response, _ := executeCode(t, server, "pass", "")
+	base := server.URL + "/sessions/" + response.ID
+
+	// Raw upload
+	req, _ := http.NewRequest(http.MethodPut, base+"/files/data/numbers.txt", strings.NewReader("1\n2\n3\n"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code 201 for raw upload, got %d", resp.StatusCode)
+	}
+
+	// Multipart upload
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "extra.txt")
+	part.Write([]byte("extra"))
+	form.Close()
+	resp, err = http.Post(base+"/files?dir=data", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code 201 for multipart upload, got %d", resp.StatusCode)
+	}
+
+	// Uploaded files are visible to code
+	response, _ = executeCode(t, server, "print(sum(int(l) for l in open('data/numbers.txt')))", response.ID)
+	if !strings.Contains(response.Stdout, "6") {
+		t.Fatalf("Expected uploaded file to be readable, got stdout '%s', stderr '%s'", response.Stdout, response.Stderr)
+	}
+
+	// Listing
+	resp, err = http.Get(base + "/files")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var listing models.FileListResponse
+	json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if len(listing.Files) != 3 {
+		t.Fatalf("Expected 3 workspace entries, got %+v", listing.Files)
+	}
+
+	// Download
+	resp, err = http.Get(base + "/files/data/extra.txt")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(content) != "extra" {
+		t.Fatalf("Expected file content 'extra', got status %d and %q", resp.StatusCode, content)
+	}
+
+	// Archive
+	resp, err = http.Get(base + "/archive")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected zip archive, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	// Delete
+	req, _ = http.NewRequest(http.MethodDelete, base+"/files/data/extra.txt", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status code 204 for delete, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(base + "/files/data/extra.txt")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404 after delete, got %d", resp.StatusCode)
+	}
+
+	// Paths leaving the workspace are rejected
+	resp, err = http.Get(base + "/files/%2e%2e/.harness/session_state.py")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400 for path outside the workspace, got %d", resp.StatusCode)
+	}
+}
+
+func TestDiskQuotaResponse(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	originalQuota := SessionDiskQuota
+	SessionDiskQuota = 1024
+	getSessionManager().SetDiskQuota(session.DiskQuota{Session: SessionDiskQuota})
+	defer func() {
+		SessionDiskQuota = originalQuota
+		getSessionManager().SetDiskQuota(session.DiskQuota{Session: originalQuota})
+	}()
+
+	response, _ := executeCode(t, server, "open('big.txt', 'w').write('x' * 4096)", "")
+	if response.DiskUsage < 4096 {
+		t.Fatalf("Expected disk usage of at least 4096 bytes, got %d", response.DiskUsage)
+	}
+
+	response, resp := executeCode(t, server, "print('over quota')", response.ID)
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("Expected status code 507, got %d", resp.StatusCode)
+	}
+
+	if response.Error == nil || response.Error.Code != models.CodeLimitExceeded || !strings.Contains(response.Error.Message, "quota") {
+		t.Fatalf("Expected quota error, got %+v", response.Error)
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go--python-executor/internal/metrics"
+	"go--python-executor/internal/models"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// ReadinessTimeout limits how long the interpreter check of /readyz may take
+var ReadinessTimeout = 5 * time.Second
+
+// startedAt is when the server process started
+var startedAt = time.Now()
+
+// HealthHandler reports that the process is alive and serving requests
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.HealthResponse{Status: "ok"})
+}
+
+// ReadyHandler reports whether the server can take executions: the Python
+// interpreter runs, the session base directory is writable and the executor
+// is not saturated. It responds with 503 Service Unavailable otherwise.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	manager := getSessionManager()
+	ctx, cancel := context.WithTimeout(r.Context(), ReadinessTimeout)
+	defer cancel()
+
+	response := models.HealthResponse{Status: "ok", Checks: map[string]string{}}
+	check := func(name string, err error) {
+		response.Checks[name] = "ok"
+		if err != nil {
+			response.Checks[name] = err.Error()
+			response.Status = "unavailable"
+		}
+	}
+
+	check("python", manager.CheckInterpreter(ctx))
+	check("base_dir", manager.CheckBaseDir())
+	var saturated error
+	if executionLimiter.Saturated() {
+		saturated = errExecutorSaturated
+	}
+	check("executor", saturated)
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// errExecutorSaturated is reported by /readyz while new executions would
+// be refused
+var errExecutorSaturated = errors.New("all execution slots are busy and the queue is full")
+
+// StatusHandler shows the state of the server to admins: sessions, running
+// and queued executions, the interpreter pool and build information
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	manager := getSessionManager()
+	executorStats := executionLimiter.Stats()
+	response := models.StatusResponse{
+		StartedAt:         startedAt.UTC().Format(time.RFC3339),
+		Uptime:            time.Since(startedAt).Round(time.Second).String(),
+		ActiveSessions:    len(manager.GetSessionCount()),
+		DiskUsage:         manager.DiskUsage(),
+		RunningExecutions: executorStats.Running,
+		QueueDepth:        executorStats.Queued,
+		Executor:          executorStats,
+		Pool:              manager.PoolStats(),
+		Build:             buildInfo(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// metricsHandler serves the Prometheus registry
+var metricsHandler = metrics.Handler()
+
+// MetricsHandler exports execution, queue, session and process metrics in
+// the Prometheus format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	// The session gauges are registered along with the session manager
+	getSessionManager()
+	metricsHandler.ServeHTTP(w, r)
+}
+
+// buildInfo describes the running binary from the information embedded by
+// the Go toolchain
+func buildInfo() models.BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return models.BuildInfo{Version: "unknown"}
+	}
+
+	build := models.BuildInfo{
+		GoVersion: info.GoVersion,
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"go--python-executor/internal/executor"
+	"go--python-executor/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// getJSON sends a GET request with an optional bearer token and decodes
+// the JSON response into v
+func getJSON(t *testing.T, url, token string, v any) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if v != nil && (resp.StatusCode < 400 || resp.StatusCode == http.StatusServiceUnavailable) {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to parse response JSON: %v", err)
+		}
+	}
+	return resp
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	var health models.HealthResponse
+	if resp := getJSON(t, server.URL+"/healthz", "", &health); resp.StatusCode != http.StatusOK || health.Status != "ok" {
+		t.Fatalf("Expected healthy status, got %d %+v", resp.StatusCode, health)
+	}
+
+	var ready models.HealthResponse
+	resp := getJSON(t, server.URL+"/readyz", "", &ready)
+	if resp.StatusCode != http.StatusOK || ready.Status != "ok" {
+		t.Fatalf("Expected ready status, got %d %+v", resp.StatusCode, ready)
+	}
+	for _, name := range []string{"python", "base_dir", "executor"} {
+		if ready.Checks[name] != "ok" {
+			t.Fatalf("Expected check %s to pass, got %q", name, ready.Checks[name])
+		}
+	}
+
+	// A saturated executor makes the server unready
+	getSessionManager()
+	executionLimiter.SetOptions(executor.Options{MaxConcurrent: 1})
+	defer executionLimiter.SetOptions(executor.Options{
+		MaxConcurrent:      MaxConcurrentExecutions,
+		MaxQueue:           MaxQueuedExecutions,
+		QueueTimeout:       QueueTimeout,
+		InteractiveReserve: InteractiveReserve,
+	})
+	release, _ := executionLimiter.Acquire(t.Context())
+	defer release()
+
+	ready = models.HealthResponse{}
+	resp = getJSON(t, server.URL+"/readyz", "", &ready)
+	if resp.StatusCode != http.StatusServiceUnavailable || ready.Checks["executor"] == "ok" {
+		t.Fatalf("Expected unready status with a failing executor check, got %d %+v", resp.StatusCode, ready)
+	}
+}
+
+func TestDebugStatus(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	// The endpoint is disabled without an admin token
+	if resp := getJSON(t, server.URL+"/debug/status", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404, got %d", resp.StatusCode)
+	}
+
+	settingsMutex.Lock()
+	AdminToken = "secret"
+	settingsMutex.Unlock()
+	defer func() {
+		settingsMutex.Lock()
+		AdminToken = ""
+		settingsMutex.Unlock()
+	}()
+
+	if resp := getJSON(t, server.URL+"/debug/status", "wrong", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status code 401, got %d", resp.StatusCode)
+	}
+
+	executeCode(t, server, "print('hello')", "")
+
+	var status models.StatusResponse
+	resp := getJSON(t, server.URL+"/debug/status", "secret", &status)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if status.ActiveSessions == 0 {
+		t.Fatal("Expected at least one active session")
+	}
+	if status.Build.GoVersion == "" || status.Executor == nil || status.Pool == nil {
+		t.Fatalf("Expected build, executor and pool information, got %+v", status)
+	}
+	if _, err := time.Parse(time.RFC3339, status.StartedAt); err != nil {
+		t.Fatalf("Expected an RFC 3339 start time, got %q", status.StartedAt)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"go--python-executor/internal/logging"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDAndExecutionLog(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var logs bytes.Buffer
+	if err := logging.Setup(&logs, "info", "json"); err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	server := LogRequests(mux)
+
+	// A client-supplied ID is echoed and used in the log
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"code": "print(1)"}`))
+	req.Header.Set(RequestIDHeader, "ticket-1234")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get(RequestIDHeader); got != "ticket-1234" {
+		t.Fatalf("Expected request ID ticket-1234, got %q", got)
+	}
+
+	var execution, served map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log lines, got %q", line)
+		}
+		switch entry["msg"] {
+		case "Execution finished":
+			execution = entry
+		case "Request served":
+			served = entry
+		}
+	}
+	if execution == nil || served == nil {
+		t.Fatalf("Expected execution and request log entries, got %s", logs.String())
+	}
+	if execution["request_id"] != "ticket-1234" || execution["outcome"] != "ok" || execution["session_id"] == "" {
+		t.Fatalf("Expected request ID, session ID and outcome in execution log, got %v", execution)
+	}
+	if _, ok := execution["duration_ms"]; !ok {
+		t.Fatalf("Expected execution duration in log, got %v", execution)
+	}
+	if served["request_id"] != "ticket-1234" || served["status"] != float64(http.StatusOK) {
+		t.Fatalf("Expected request ID and status in request log, got %v", served)
+	}
+
+	// Missing or malformed IDs are replaced by a generated one
+	for _, given := range []string{"", "bad id\n"} {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set(RequestIDHeader, given)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		got := rec.Header().Get(RequestIDHeader)
+		if got == "" || got == given {
+			t.Fatalf("Expected a generated request ID for %q, got %q", given, got)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	executeCode(t, server, "print('ok')", "")
+	executeCode(t, server, "raise ValueError()", "")
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	for _, metric := range []string{
+		`pyexec_executions_total{outcome="ok"}`,
+		`pyexec_executions_total{outcome="error"}`,
+		`pyexec_executions_total{outcome="timeout"}`,
+		`pyexec_executions_total{outcome="limit_exceeded"}`,
+		`pyexec_execution_duration_seconds_bucket{outcome="ok"`,
+		`pyexec_queue_duration_seconds_count`,
+		`pyexec_active_sessions`,
+		`pyexec_running_processes`,
+		`pyexec_queued_executions`,
+		`pyexec_disk_usage_bytes`,
+		`pyexec_sessions_created_total`,
+		`pyexec_sessions_expired_total`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Fatalf("Expected metric %s in output:\n%s", metric, body)
+		}
+	}
+	if strings.Contains(string(body), `pyexec_executions_total{outcome="ok"} 0`) {
+		t.Fatal("Expected successful executions to be counted")
+	}
+}
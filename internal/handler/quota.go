@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/ratelimit"
+	"go--python-executor/internal/session"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountLimits returns the configured per-account limits. Callers must
+// hold settingsMutex.
+func accountLimits() ratelimit.Options {
+	return ratelimit.Options{
+		RequestsPerSecond: RateLimit,
+		Burst:             RateLimitBurst,
+		DailyCPUSeconds:   DailyCPUSeconds,
+		MaxSessions:       MaxAccountSessions,
+	}
+}
+
+// account returns the name the limits of r are counted against: the
+// caller's tenant or key, or the client address when authentication is
+// disabled. Behind a trusted proxy that is the forwarded address, so
+// clients do not share the proxy's limits.
+func account(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return principal.Account()
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the address of the client of r without its port. When
+// the request comes from a trusted proxy, the client is the last address in
+// X-Forwarded-For that is not a trusted proxy itself, or X-Real-IP if there
+// is no X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := settings().TrustedProxies
+	if !trusted(proxies, host) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Anything before a malformed entry cannot be trusted
+			return host
+		}
+		host = addr.Unmap().String()
+		if !trusted(proxies, host) {
+			return host
+		}
+	}
+	if len(forwarded) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return host
+}
+
+// trusted reports whether host is one of the trusted proxies
+func trusted(proxies []netip.Prefix, host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range proxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds rounds d up to whole seconds for response headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// allowRequest takes a token from the bucket of the caller of r, sets the
+// RateLimit headers and sends 429 Too Many Requests if the bucket is empty
+func allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	getSessionManager()
+	decision, err := accountLimiter.Allow(account(r))
+	if decision.Limit > 0 {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(decision.Reset))
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", seconds(decision.Reset))
+		sendErrorResponse(w, http.StatusTooManyRequests, models.CodeLimitExceeded, "", err.Error())
+		return false
+	}
+	return true
+}
+
+// sendQuotaError reports an execution or session refused by an account
+// quota and returns whether it was
+func sendQuotaError(w http.ResponseWriter, sessionID string, err error) bool {
+	switch {
+	case errors.Is(err, ratelimit.ErrCPUQuotaExceeded):
+		w.Header().Set("Retry-After", seconds(time.Until(accountLimiter.CPUReset())))
+	case errors.Is(err, ratelimit.ErrSessionQuotaExceeded):
+	case errors.Is(err, session.ErrTenantSessionLimit):
+	default:
+		return false
+	}
+	sendErrorResponse(w, http.StatusTooManyRequests, models.CodeLimitExceeded, sessionID, err.Error())
+	return true
+}
+
+// reserveSession makes room for a session the caller of r is about to
+// create. The returned function records the created session by its key,
+// or releases the reservation when called with an empty key.
+func reserveSession(w http.ResponseWriter, r *http.Request) (func(sessionID string), bool) {
+	getSessionManager()
+	commit, err := accountLimiter.ReserveSession(account(r))
+	if sendQuotaError(w, "", err) {
+		return nil, false
+	}
+	return commit, true
+}
+
+// QuotaHandler reports the caller's request rate, CPU time and session
+// usage against its limits
+func QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	getSessionManager()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accountLimiter.Usage(account(r)))
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/ratelimit"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// configureLimits enables API keys with the given secrets, named after
+// them, if any, and applies the limits set by adjust. The default configuration
+// is restored when the test ends. Accounts start without usage, so tests
+// can be repeated.
+func configureLimits(t *testing.T, adjust func(cfg *config.Config), secrets ...string) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	var keys []auth.Key
+	for _, secret := range secrets {
+		keys = append(keys, auth.Key{ID: secret, SHA256: auth.HashSecret(secret)})
+	}
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	os.WriteFile(file, data, 0644)
+
+	getSessionManager()
+	if err := Configure(config.Default()); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	cfg := config.Default()
+	if len(secrets) > 0 {
+		cfg.APIKeysFile = file
+	}
+	adjust(cfg)
+	applyConfig(cfg)
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	resetAccounts()
+	t.Cleanup(func() {
+		applyConfig(config.Default())
+		Configure(config.Default())
+		currentConfig = nil
+		resetAccounts()
+	})
+}
+
+// resetAccounts replaces the account limiter with one that has not counted
+// anything yet
+func resetAccounts() {
+	alive := getSessionManager().HasSession
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	accountLimiter = ratelimit.New(accountLimits(), alive)
+}
+
+// getQuota requests the quota usage of the given key
+func getQuota(t *testing.T, url, key string) (*ratelimit.Usage, *http.Response) {
+	req, _ := http.NewRequest(http.MethodGet, url+"/quota", nil)
+	req.Header.Set(auth.APIKeyHeader, key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var usage ratelimit.Usage
+	json.NewDecoder(resp.Body).Decode(&usage)
+	return &usage, resp
+}
+
+func TestRateLimit(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+	configureLimits(t, func(cfg *config.Config) {
+		cfg.RateLimit = 0.1
+		cfg.RateLimitBurst = 2
+	}, "rate-a", "rate-b")
+
+	for i := 1; i >= 0; i-- {
+		_, resp := getQuota(t, server.URL, "rate-a")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+		}
+		if resp.Header.Get("RateLimit-Limit") != "2" || resp.Header.Get("RateLimit-Remaining") != fmt.Sprint(i) {
+			t.Fatalf("Unexpected rate limit headers: %v", resp.Header)
+		}
+	}
+
+	_, resp := getQuota(t, server.URL, "rate-a")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected status code 429 with Retry-After, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Every key has its own bucket
+	if _, resp := getQuota(t, server.URL, "rate-b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200 for another key, got %d", resp.StatusCode)
+	}
+}
+
+func TestAnonymousRateLimitBehindProxy(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+	configureLimits(t, func(cfg *config.Config) {
+		cfg.RateLimit = 0.1
+		cfg.RateLimitBurst = 1
+		cfg.TrustedProxies = "127.0.0.1"
+	})
+
+	// Without authentication, clients behind the proxy have their own
+	// buckets
+	quota := func(client string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/quota", nil)
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := quota("198.51.100.1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if resp := quota("198.51.100.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", resp.StatusCode)
+	}
+	if resp := quota("198.51.100.2"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200 for another client, got %d", resp.StatusCode)
+	}
+}
+
+func TestAccountQuotas(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+	configureLimits(t, func(cfg *config.Config) {
+		cfg.MaxAccountSessions = 1
+		cfg.DailyCPUSeconds = 0.2
+	}, "quota-a")
+
+	response, status := executeAs(t, server, "quota-a", "x = 1", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", status, response.Error)
+	}
+	id := response.ID
+
+	// A second session exceeds the session quota
+	if _, status := executeAs(t, server, "quota-a", "x = 2", ""); status != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", status)
+	}
+
+	// Burn more CPU time than the daily quota allows
+	burn := "import time\nstart = time.process_time()\nwhile time.process_time() - start < 0.3:\n    pass"
+	if _, status := executeAs(t, server, "quota-a", burn, id); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	response, status = executeAs(t, server, "quota-a", "print(x)", id)
+	if status != http.StatusTooManyRequests || response.Error == nil || response.Error.Code != models.CodeLimitExceeded || response.Error.Message != ratelimit.ErrCPUQuotaExceeded.Error() {
+		t.Fatalf("Expected CPU quota to be exceeded, got %d %+v", status, response)
+	}
+
+	usage, _ := getQuota(t, server.URL, "quota-a")
+	if usage.Account != "key:quota-a" || usage.Sessions != 1 || usage.SessionLimit != 1 || usage.CPUSeconds < 0.3 || usage.CPUSecondsLimit != 0.2 {
+		t.Fatalf("Unexpected quota usage: %+v", usage)
+	}
+}
+
+func TestReplayChargesCPU(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+	configureLimits(t, func(cfg *config.Config) {
+		cfg.DailyCPUSeconds = 0.45
+	}, "replay-a")
+
+	burn := "import time\nstart = time.process_time()\nwhile time.process_time() - start < 0.25:\n    pass"
+	response, status := executeAs(t, server, "replay-a", burn, "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+
+	replay := func() int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/sessions/"+response.ID+"/replay", nil)
+		req.Header.Set(auth.APIKeyHeader, "replay-a")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Replaying burns the CPU time again, which exhausts the quota
+	if status := replay(); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	usage, _ := getQuota(t, server.URL, "replay-a")
+	if usage.CPUSeconds < 0.5 {
+		t.Fatalf("Expected the replay to be charged, got %+v", usage)
+	}
+	if status := replay(); status != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", status)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	settingsMutex.Lock()
+	trustedProxies, _ = config.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	settingsMutex.Unlock()
+	defer func() {
+		settingsMutex.Lock()
+		trustedProxies = nil
+		settingsMutex.Unlock()
+	}()
+
+	for _, tc := range []struct {
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		// Headers from clients that are not trusted proxies are ignored
+		{"203.0.113.5:4000", "198.51.100.7", "198.51.100.8", "203.0.113.5"},
+		// The last untrusted address in the chain is the client
+		{"192.0.2.1:4000", "198.51.100.7", "", "198.51.100.7"},
+		{"10.1.2.3:4000", "6.6.6.6, 198.51.100.7, 10.9.9.9", "", "198.51.100.7"},
+		{"192.0.2.1:4000", "", "198.51.100.8", "198.51.100.8"},
+		{"192.0.2.1:4000", "", "", "192.0.2.1"},
+		{"192.0.2.1:4000", "garbage", "", "192.0.2.1"},
+		{"[::ffff:10.0.0.1]:4000", "198.51.100.7", "", "198.51.100.7"},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := clientIP(r); got != tc.expected {
+			t.Fatalf("Expected client %s for %+v, got %s", tc.expected, tc, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"go--python-executor/internal/models"
+	"net/http"
+	"strings"
+)
+
+// RegisterRoutes adds every API endpoint to mux. When authentication is
+// enabled, all but the probe, metrics and admin endpoints require an API
+// key or JWT; admin endpoints use the admin token.
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/execute", authenticated(ExecuteHandler))
+	mux.HandleFunc("POST /sessions", authenticated(CreateSessionHandler))
+	mux.HandleFunc("GET /sessions", authenticated(ListSessionsHandler))
+	mux.HandleFunc("GET /sessions/{id}", authenticated(SessionInfoHandler))
+	mux.HandleFunc("GET /sessions/{id}/history", authenticated(HistoryHandler))
+	mux.HandleFunc("POST /sessions/{id}/replay", authenticated(ReplayHandler))
+	mux.HandleFunc("POST /sessions/{id}/install", authenticated(InstallHandler))
+	mux.HandleFunc("GET /sessions/{id}/files", authenticated(ListFilesHandler))
+	mux.HandleFunc("POST /sessions/{id}/files", authenticated(UploadMultipartHandler))
+	mux.HandleFunc("GET /sessions/{id}/files/{path...}", authenticated(DownloadFileHandler))
+	mux.HandleFunc("PUT /sessions/{id}/files/{path...}", authenticated(UploadFileHandler))
+	mux.HandleFunc("DELETE /sessions/{id}/files/{path...}", authenticated(DeleteFileHandler))
+	mux.HandleFunc("GET /sessions/{id}/archive", authenticated(DownloadArchiveHandler))
+	mux.HandleFunc("GET /quota", authenticated(QuotaHandler))
+	mux.HandleFunc("GET /pool", authenticated(PoolStatsHandler))
+	mux.HandleFunc("GET /executor", authenticated(ExecutorStatsHandler))
+	mux.HandleFunc("POST /admin/reload", ReloadHandler)
+	mux.HandleFunc("GET /admin/audit", AuditHandler)
+	mux.HandleFunc("GET /healthz", HealthHandler)
+	mux.HandleFunc("GET /readyz", ReadyHandler)
+	mux.HandleFunc("GET /debug/status", StatusHandler)
+	mux.HandleFunc("GET /metrics", MetricsHandler)
+}
+
+// RouteErrors answers the requests mux has no endpoint or method for with
+// JSON error responses like every other failed request, instead of the
+// plain text the mux sends
+func RouteErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		out := &routeErrorWriter{ResponseWriter: w}
+		mux.ServeHTTP(out, r)
+		if out.status != 0 {
+			sendErrorResponse(w, out.status, models.CodeInvalidRequest, "", strings.ToLower(http.StatusText(out.status)))
+		}
+	})
+}
+
+// routeErrorWriter holds back the 404 and 405 responses of the mux and
+// passes anything else, such as redirects, through
+type routeErrorWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (e *routeErrorWriter) WriteHeader(status int) {
+	if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		e.status = status
+		return
+	}
+	e.ResponseWriter.WriteHeader(status)
+}
+
+func (e *routeErrorWriter) Write(p []byte) (int, error) {
+	if e.status != 0 {
+		return len(p), nil
+	}
+	return e.ResponseWriter.Write(p)
+}
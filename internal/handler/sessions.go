@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go--python-executor/internal/executor"
+	"go--python-executor/internal/metrics"
+	"go--python-executor/internal/models"
+	"go--python-executor/internal/session"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CreateSessionHandler creates a session with a server-issued ID
+func CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", "invalid request payload")
+			return
+		}
+	}
+
+	lifetime, err := sessionLifetime(req.IdleTimeout, req.MaxLifetime)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", err.Error())
+		return
+	}
+
+	sessions := tenantSessions(r)
+	caller := account(r)
+	if len(req.Requirements) > 0 {
+		if err := accountLimiter.CheckCPU(caller); sendQuotaError(w, "", err) {
+			return
+		}
+	}
+	commit, ok := reserveSession(w, r)
+	if !ok {
+		return
+	}
+	sess, err := sessions.CreateSession(session.CreateOptions{Lifetime: lifetime, Owner: owner(r)})
+	if err != nil {
+		commit("")
+		if !sendQuotaError(w, "", err) {
+			sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, "", "failed to create session: "+err.Error())
+		}
+		return
+	}
+	commit(sess.Key())
+
+	// Install the requirements before handing out the session, and do not
+	// keep a session whose environment is incomplete
+	var output bytes.Buffer
+	if len(req.Requirements) > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), installTimeout(r))
+		defer cancel()
+
+		usage, err := sess.InstallPackages(ctx, req.Requirements, &output)
+		accountLimiter.AddCPU(caller, usage.CPUTime)
+		if err != nil {
+			sessions.DeleteSession(sess.ID)
+			status, code := installError(err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(models.CreateSessionResponse{
+				SessionInfo:   models.SessionInfo{ID: sess.ID},
+				InstallOutput: output.String() + err.Error() + "\n",
+				Error:         &models.Error{Code: code, Message: err.Error()},
+			})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateSessionResponse{
+		SessionInfo:   sessionInfo(sess),
+		InstallOutput: output.String(),
+	})
+}
+
+// InstallHandler installs packages from the wheelhouse into a session's
+// virtual environment, streaming the installer output as plain text. The
+// outcome is reported in the X-Install-Status trailer since the status code
+// is sent before installation starts.
+func InstallHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	var req models.InstallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, models.CodeInvalidRequest, "", "invalid request payload")
+		return
+	}
+
+	caller := account(r)
+	if err := accountLimiter.CheckCPU(caller); sendQuotaError(w, id, err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), installTimeout(r))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", "X-Install-Status")
+	out := &flushWriter{w: w, rc: http.NewResponseController(w)}
+
+	usage, err := sess.InstallPackages(ctx, req.Packages, out)
+	accountLimiter.AddCPU(caller, usage.CPUTime)
+	if err != nil {
+		if !out.written {
+			// Nothing was streamed yet, so a proper status can still be sent
+			w.Header().Del("Trailer")
+			if sendBusyError(w, sess.ID, err) {
+				return
+			}
+			status, code := installError(err)
+			sendErrorResponse(w, status, code, sess.ID, err.Error())
+			return
+		}
+		fmt.Fprintln(out, err)
+		w.Header().Set("X-Install-Status", "failed")
+		return
+	}
+	w.Header().Set("X-Install-Status", "ok")
+}
+
+// installTimeout is how long an installation may take: the configured
+// install timeout, capped at the longest execution the caller may run
+func installTimeout(r *http.Request) time.Duration {
+	return executionLimits(r, settings().InstallTimeout).Timeout
+}
+
+// installError maps an installation failure to an HTTP status and error
+// code. Requirements that cannot be satisfied from the wheelhouse are the
+// caller's to fix.
+func installError(err error) (int, models.ErrorCode) {
+	switch {
+	case errors.Is(err, session.ErrInstallDisabled):
+		return http.StatusNotImplemented, models.CodePolicyViolation
+	case errors.Is(err, session.ErrInvalidRequirement):
+		return http.StatusBadRequest, models.CodeInvalidRequest
+	case errors.Is(err, session.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, models.CodeLimitExceeded
+	case errors.Is(err, executor.ErrQueueFull), errors.Is(err, executor.ErrQueueTimeout):
+		return http.StatusTooManyRequests, models.CodeLimitExceeded
+	default:
+		return http.StatusUnprocessableEntity, models.CodeInvalidRequest
+	}
+}
+
+// flushWriter flushes every write to the client so output is streamed
+type flushWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	written bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.written = true
+	n, err := f.w.Write(p)
+	f.rc.Flush()
+	return n, err
+}
+
+// SessionInfoHandler describes an existing session
+func SessionInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionInfo(sess))
+}
+
+// ListSessionsHandler lists the sessions of the caller's tenant that the
+// caller may use, oldest first
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions := tenantSessions(r)
+	response := models.SessionListResponse{
+		Tenant:   sessions.Name(),
+		Sessions: []models.SessionInfo{},
+	}
+	for _, sess := range sessions.ListSessions() {
+		if sess.Owner() == owner(r) {
+			response.Sessions = append(response.Sessions, sessionInfo(sess))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sessionInfo builds the public description of a session
+func sessionInfo(sess *session.Session) models.SessionInfo {
+	current := settings()
+	return models.SessionInfo{
+		ID:        sess.ID,
+		CreatedAt: sess.CreatedAt().UTC().Format(time.RFC3339),
+		LastUsed:  sess.LastUsed().UTC().Format(time.RFC3339),
+		ExpiresAt: sess.ExpiresAt(current.SessionTimeLimit).UTC().Format(time.RFC3339),
+		DiskUsage: sess.DiskUsage(),
+		DiskQuota: current.SessionDiskQuota,
+		Venv:      sess.HasVenv(),
+	}
+}
+
+// HistoryHandler returns the ordered execution history of a session
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sess, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	history, err := sess.History()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, sess.ID, "failed to load session history: "+err.Error())
+		return
+	}
+
+	response := models.HistoryResponse{
+		ID:      sess.ID,
+		History: make([]models.HistoryEntry, 0, len(history)),
+	}
+	for _, entry := range history {
+		response.History = append(response.History, models.HistoryEntry{
+			Code:       entry.Code,
+			StartedAt:  entry.StartedAt.UTC().Format(time.RFC3339Nano),
+			DurationMs: entry.DurationMs,
+			Stdout:     entry.Stdout,
+			Stderr:     entry.Stderr,
+			Status:     entry.Status,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReplayHandler rebuilds a fresh session by re-executing a session's history
+func ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	source, ok := lookupSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	caller := account(r)
+	if err := accountLimiter.CheckCPU(caller); sendQuotaError(w, id, err) {
+		metrics.LimitExceeded()
+		return
+	}
+	commit, ok := reserveSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Replaying runs every past execution in one go, within the limits
+	// granted to the caller, and counts against the caller's CPU quota
+	limits := executionLimits(r, settings().ReplayTimeout)
+	code := replayedCode(source)
+	start := time.Now()
+	replayed, usage, err := tenantSessions(r).ReplaySession(r.Context(), id, limits)
+	duration := time.Since(start)
+	accountLimiter.AddCPU(caller, usage.CPUTime)
+	replayedID := ""
+	if err != nil {
+		commit("")
+	} else {
+		commit(replayed.Key())
+		replayedID = replayed.ID
+	}
+
+	// record audits the replay like an execution of the whole history
+	record := func(outcome string) {
+		auditReplay(r, replayedID, id, code, outcome, usage, duration, err)
+	}
+	if errors.Is(err, session.ErrSessionNotFound) || errors.Is(err, session.ErrInvalidSessionID) {
+		sendSessionError(w, id, err)
+		return
+	}
+	if sendBusyError(w, id, err) || sendQuotaError(w, id, err) {
+		metrics.LimitExceeded()
+		record(metrics.OutcomeLimitExceeded)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		record(metrics.OutcomeTimeout)
+		sendErrorResponse(w, http.StatusGatewayTimeout, models.CodeTimeout, id, "replay timeout")
+		return
+	}
+	if err != nil {
+		record(metrics.OutcomeError)
+		sendErrorResponse(w, http.StatusInternalServerError, models.CodeInternal, id, err.Error())
+		return
+	}
+	record(metrics.OutcomeOK)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReplayResponse{
+		ID:           replayed.ID,
+		ReplayedFrom: id,
+	})
+}
+
+// replayedCode returns the code a replay of sess runs: its successful
+// executions in order
+func replayedCode(sess *session.Session) string {
+	history, _ := sess.History()
+	var codes []string
+	for _, entry := range history {
+		if entry.Status == session.StatusOK {
+			codes = append(codes, entry.Code)
+		}
+	}
+	return strings.Join(codes, "\n")
+}
+
+// PoolStatsHandler reports the size and hit/miss counts of the warm
+// interpreter pool
+func PoolStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getSessionManager().PoolStats())
+}
+
+// ExecutorStatsHandler reports the concurrency limit, queue depth and
+// admission counters of the executor
+func ExecutorStatsHandler(w http.ResponseWriter, r *http.Request) {
+	// The limiter is created along with the session manager
+	getSessionManager()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(executionLimiter.Stats())
+}
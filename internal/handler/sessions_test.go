@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"encoding/json"
+	"go--python-executor/internal/auth"
+	"go--python-executor/internal/config"
+	"go--python-executor/internal/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionHistory(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	response, _ := executeCode(t, server, "x = 1", "")
+	sessionID := response.ID
+	executeCode(t, server, "print(x + 1)", sessionID)
+
+	resp, err := http.Get(server.URL + "/sessions/" + sessionID + "/history")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	var history models.HistoryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+
+	if len(history.History) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(history.History))
+	}
+
+	if history.History[1].Code != "print(x + 1)" || history.History[1].Stdout != "2\n" {
+		t.Fatalf("Unexpected history entry: %+v", history.History[1])
+	}
+
+	// Unknown sessions have no history
+	resp, err = http.Get(server.URL + "/sessions/unknown-session/history")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404 for unknown session, got %d", resp.StatusCode)
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	response, _ := executeCode(t, server, "total = 0", "")
+	sessionID := response.ID
+	executeCode(t, server, "total += 5", sessionID)
+
+	resp, err := http.Post(server.URL+"/sessions/"+sessionID+"/replay", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	var replay models.ReplayResponse
+	if err := json.NewDecoder(resp.Body).Decode(&replay); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+
+	if replay.ID == "" || replay.ID == sessionID || replay.ReplayedFrom != sessionID {
+		t.Fatalf("Unexpected replay response: %+v", replay)
+	}
+
+	response, _ = executeCode(t, server, "print(total)", replay.ID)
+	if !strings.Contains(response.Stdout, "5") {
+		t.Fatalf("Expected replayed session to contain total 5, got '%s'", response.Stdout)
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/sessions", "application/json", strings.NewReader(`{"idle_timeout": 60}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code 201, got %d", resp.StatusCode)
+	}
+
+	var info models.SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+
+	if info.ID == "" || info.ExpiresAt == "" {
+		t.Fatalf("Expected session ID and expiry, got %+v", info)
+	}
+
+	// The minted session can be used for execution and described
+	response, _ := executeCode(t, server, "print('minted')", info.ID)
+	if response.ID != info.ID || !strings.Contains(response.Stdout, "minted") {
+		t.Fatalf("Expected execution in session %s, got %+v", info.ID, response)
+	}
+
+	resp, err = http.Get(server.URL + "/sessions/" + info.ID)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestStrictSessions(t *testing.T) {
+	originalStrict := StrictSessions
+	StrictSessions = true
+	defer func() { StrictSessions = originalStrict }()
+
+	server := setupTestServer()
+	defer server.Close()
+
+	// Sessions without an ID are still created by the server
+	response, resp := executeCode(t, server, "x = 1", "")
+	if resp.StatusCode != http.StatusOK || response.ID == "" {
+		t.Fatalf("Expected a new session, got status %d and %+v", resp.StatusCode, response)
+	}
+
+	// Unknown IDs are rejected rather than silently created
+	response, resp = executeCode(t, server, "print(x)", "client-chosen-id")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404 for unknown session, got %d", resp.StatusCode)
+	}
+
+	if response.Error == nil || response.Error.Code != models.CodeSessionNotFound {
+		t.Fatalf("Expected session not found error, got %+v", response.Error)
+	}
+}
+
+func TestInvalidSessionID(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	for _, id := range []string{"../escape", "a/b", ".hidden", strings.Repeat("x", 65)} {
+		_, resp := executeCode(t, server, "print('nope')", id)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code 400 for ID %q, got %d", id, resp.StatusCode)
+		}
+	}
+}
+
+func TestInstallErrors(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	response, _ := executeCode(t, server, "pass", "")
+
+	// Installation is disabled without a wheelhouse
+	resp, err := http.Post(server.URL+"/sessions/"+response.ID+"/install", "application/json", strings.NewReader(`{"packages": ["numpy"]}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("Expected status code 501 without a wheelhouse, got %d", resp.StatusCode)
+	}
+
+	getSessionManager().SetWheelhouse(t.TempDir())
+	defer getSessionManager().SetWheelhouse(Wheelhouse)
+
+	resp, err = http.Post(server.URL+"/sessions/"+response.ID+"/install", "application/json", strings.NewReader(`{"packages": ["--index-url=http://example.com"]}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400 for an option-like requirement, got %d", resp.StatusCode)
+	}
+
+	// Sessions whose requirements cannot be installed are not created
+	resp, err = http.Post(server.URL+"/sessions", "application/json", strings.NewReader(`{"requirements": ["../pkg"]}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	var created models.CreateSessionResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	if resp.StatusCode != http.StatusBadRequest || created.InstallOutput == "" {
+		t.Fatalf("Expected status code 400 with install output, got %d and %+v", resp.StatusCode, created)
+	}
+	if _, err := getSessionManager().GetSession(created.ID); err == nil {
+		t.Fatal("Expected the session to be removed after a failed installation")
+	}
+}
+
+func TestTenantSessionIsolation(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	data, _ := json.Marshal(map[string]any{"keys": []auth.Key{
+		{ID: "alice", SHA256: auth.HashSecret("alice-secret"), Tenant: "hooli"},
+		{ID: "carol", SHA256: auth.HashSecret("carol-secret"), Tenant: "hooli"},
+		{ID: "bob", SHA256: auth.HashSecret("bob-secret"), Tenant: "piedpiper"},
+	}})
+	os.WriteFile(file, data, 0644)
+	configureLimits(t, func(cfg *config.Config) {
+		cfg.APIKeysFile = file
+		cfg.MaxTenantSessions = 2
+	})
+
+	// Sessions restored from earlier runs would count against the limit
+	for _, name := range []string{"hooli", "piedpiper"} {
+		sessions := getSessionManager().Tenant(name)
+		for _, sess := range sessions.ListSessions() {
+			sessions.DeleteSession(sess.ID)
+		}
+	}
+
+	request := func(method, path, key string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	response, status := executeAs(t, server, "alice-secret", "x = 1", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", status, response.Error)
+	}
+	id := response.ID
+
+	// Another tenant cannot tell the session exists
+	resp := request(http.MethodGet, "/sessions/"+id, "bob-secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404, got %d", resp.StatusCode)
+	}
+
+	// The same ID names a separate session in every tenant
+	if response, status := executeAs(t, server, "alice-secret", "name = 'hooli'", "shared"); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", status, response.Error)
+	}
+	response, status = executeAs(t, server, "bob-secret", "print(globals().get('name'))", "shared")
+	if status != http.StatusOK || response.Stdout != "None\n" {
+		t.Fatalf("Expected an empty session for piedpiper, got %d %+v", status, response)
+	}
+
+	// Listing only shows the caller's sessions of its tenant
+	resp = request(http.MethodGet, "/sessions", "alice-secret")
+	var list models.SessionListResponse
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if list.Tenant != "hooli" || len(list.Sessions) != 2 {
+		t.Fatalf("Expected 2 sessions of hooli, got %+v", list)
+	}
+	resp = request(http.MethodGet, "/sessions", "bob-secret")
+	list = models.SessionListResponse{}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if list.Tenant != "piedpiper" || len(list.Sessions) != 1 || list.Sessions[0].ID != "shared" {
+		t.Fatalf("Expected the shared session of piedpiper, got %+v", list)
+	}
+
+	// The tenant is full, even for another key of it, while others are not
+	resp = request(http.MethodPost, "/sessions", "carol-secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", resp.StatusCode)
+	}
+	resp = request(http.MethodPost, "/sessions", "bob-secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code 201, got %d", resp.StatusCode)
+	}
+}
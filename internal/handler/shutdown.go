@@ -0,0 +1,25 @@
+package handler
+
+import "log/slog"
+
+// Shutdown stops the cleanup loop and closes the session manager. It must
+// be called once the HTTP server has stopped serving requests. Sessions are
+// kept for the next start or removed according to ShutdownSessions.
+func Shutdown() error {
+	// Make sure no manager is started from here on
+	once.Do(func() {})
+	if sessionManager == nil {
+		return nil
+	}
+
+	stopCleanup()
+	<-cleanupDone
+
+	if settings().ShutdownSessions == "cleanup" {
+		slog.Info("Removing sessions", "sessions", len(sessionManager.GetSessionCount()))
+		sessionManager.RemoveSessions()
+	} else {
+		slog.Info("Keeping sessions for the next start", "sessions", len(sessionManager.GetSessionCount()))
+	}
+	return sessionManager.Close()
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"go--python-executor/internal/session"
+	"testing"
+	"time"
+)
+
+func TestCleanupLoopStopsOnCancel(t *testing.T) {
+	originalInterval, originalTimeLimit := CleanupInterval, SessionTimeLimit
+	CleanupInterval, SessionTimeLimit = 10*time.Millisecond, 50*time.Millisecond
+	defer func() { CleanupInterval, SessionTimeLimit = originalInterval, originalTimeLimit }()
+
+	manager, err := session.NewManagerWithOptions(session.Options{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cleanupLoop(ctx, manager)
+	}()
+
+	// The loop removes the session once it expires
+	manager.GetOrCreateSession("")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(manager.GetSessionCount()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the cleanup loop to remove the expired session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cleanup loop to stop when its context is cancelled")
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"go--python-executor/internal/logging"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExecutionSpans(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var logs bytes.Buffer
+	if err := logging.Setup(&logs, "info", "json"); err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	server := TraceRequests(LogRequests(mux))
+
+	// Continue the trace of the caller
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"code": "print(1)"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"/execute", "session.lookup", "session.admit", "session.load_state", "session.execute", "session.spawn_process", "session.save_state"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected a %s span, got %v", name, spans)
+		}
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Fatalf("Expected %s span in trace %s, got %s", name, traceID, got)
+		}
+	}
+
+	if parent := spans["/execute"].Parent().SpanID().String(); parent != "00f067aa0ba902b7" {
+		t.Fatalf("Expected request span to continue the caller's span, got parent %s", parent)
+	}
+	if parent := spans["session.spawn_process"].Parent().SpanID(); parent != spans["session.execute"].SpanContext().SpanID() {
+		t.Fatalf("Expected process spawn within the execution span")
+	}
+
+	// The request log entry is written within the request span
+	var served map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == "Request served" {
+			served = entry
+		}
+	}
+	if served == nil || served["trace_id"] != traceID {
+		t.Fatalf("Expected the request log entry in trace %s, got %v", traceID, served)
+	}
+}
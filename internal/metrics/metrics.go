@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"context"
+	"go--python-executor/internal/executor"
+	"go--python-executor/internal/session"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "pyexec"
+
+// Execution outcomes used as the outcome label
+const (
+	OutcomeOK            = "ok"
+	OutcomeError         = "error"
+	OutcomeTimeout       = "timeout"
+	OutcomeLimitExceeded = "limit_exceeded"
+)
+
+// Registry holds every metric exported on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	executions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "executions_total",
+		Help:      "Executions by outcome: ok, error, timeout or limit_exceeded.",
+	}, []string{"outcome"})
+
+	executionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "execution_duration_seconds",
+		Help:      "Time the interpreter ran per execution, by outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+	}, []string{"outcome"})
+
+	queueDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_duration_seconds",
+		Help:      "Time executions waited for an interpreter slot.",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10},
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		executions,
+		executionDuration,
+		queueDuration,
+	)
+
+	// Start every outcome at zero so rates work from the first scrape
+	for _, outcome := range []string{OutcomeOK, OutcomeError, OutcomeTimeout, OutcomeLimitExceeded} {
+		executions.WithLabelValues(outcome)
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveExecution counts a finished execution and records how long the
+// interpreter ran. It matches session.ExecutionObserver, whose history
+// statuses are used as outcomes.
+func ObserveExecution(status string, duration time.Duration) {
+	executions.WithLabelValues(status).Inc()
+	executionDuration.WithLabelValues(status).Observe(duration.Seconds())
+}
+
+// LimitExceeded counts an execution refused by a quota or admission control
+func LimitExceeded() {
+	executions.WithLabelValues(OutcomeLimitExceeded).Inc()
+}
+
+// timedLimiter records how long executions wait for a slot
+type timedLimiter struct {
+	session.Limiter
+}
+
+// TimeLimiter wraps limiter so that queue durations are recorded
+func TimeLimiter(limiter session.Limiter) session.Limiter {
+	return timedLimiter{limiter}
+}
+
+// Acquire waits for a slot and records the wait
+func (l timedLimiter) Acquire(ctx context.Context) (func(), error) {
+	start := time.Now()
+	release, err := l.Limiter.Acquire(ctx)
+	if err == nil {
+		queueDuration.Observe(time.Since(start).Seconds())
+	}
+	return release, err
+}
+
+// RegisterServer exports gauges and counters read from the session manager
+// and executor at scrape time
+func RegisterServer(manager *session.Manager, limiter *executor.Limiter) {
+	gauge := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, value)
+	}
+	counter := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, value)
+	}
+
+	Registry.MustRegister(
+		gauge("active_sessions", "Sessions currently held by the server.", func() float64 {
+			return float64(len(manager.GetSessionCount()))
+		}),
+		gauge("running_processes", "Interpreter processes running an execution.", func() float64 {
+			return float64(limiter.Stats().Running)
+		}),
+		gauge("queued_executions", "Executions waiting for an interpreter slot.", func() float64 {
+			return float64(limiter.Stats().Queued)
+		}),
+		gauge("disk_usage_bytes", "Disk space used by all sessions.", func() float64 {
+			return float64(manager.DiskUsage())
+		}),
+		gauge("warm_pool_idle", "Pre-started interpreters waiting in the pool.", func() float64 {
+			return float64(manager.PoolStats().Idle)
+		}),
+		counter("warm_pool_hits_total", "Executions that ran in a pre-started interpreter.", func() float64 {
+			return float64(manager.PoolStats().Hits)
+		}),
+		counter("warm_pool_misses_total", "Executions that found the pool empty.", func() float64 {
+			return float64(manager.PoolStats().Misses)
+		}),
+		counter("sessions_created_total", "Sessions created.", func() float64 {
+			return float64(manager.SessionsCreated())
+		}),
+		counter("sessions_expired_total", "Sessions removed because they expired.", func() float64 {
+			return float64(manager.SessionsExpired())
+		}),
+		counter("sessions_evicted_total", "Sessions removed to get back under the global disk quota.", func() float64 {
+			return float64(manager.SessionsEvicted())
+		}),
+	)
+}
@@ -4,12 +4,216 @@ package models
 type RequestPayload struct {
 	ID   string `json:"id,omitempty"`
 	Code string `json:"code"`
+	// IdleTimeout and MaxLifetime (in seconds) apply when a new session is
+	// created; zero uses the server defaults
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+	MaxLifetime int `json:"max_lifetime,omitempty"`
+	// Priority is interactive, normal or batch and orders the execution
+	// when it has to wait for an interpreter; empty means normal
+	Priority string `json:"priority,omitempty"`
+	// Runtime selects the interpreter; empty means python, currently the
+	// only one
+	Runtime string `json:"runtime,omitempty"`
 }
 
-// ResponsePayload represents the execution result
+// ResponsePayload represents the execution result. Error is only set when
+// the request failed; errors raised by the code itself are in Stderr.
 type ResponsePayload struct {
-	ID     string `json:"id,omitempty"`
-	Stdout string `json:"stdout,omitempty"`
-	Stderr string `json:"stderr,omitempty"`
-	Error  string `json:"error,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Stdout    string `json:"stdout,omitempty"`
+	Stderr    string `json:"stderr,omitempty"`
+	Error     *Error `json:"error,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	DiskUsage int64  `json:"disk_usage,omitempty"`
+	// ExitCode is the exit status of an interpreter that exited with a
+	// failure, such as after an uncaught exception or sys.exit(3)
+	ExitCode int `json:"exit_code,omitempty"`
+	// Unrestorable names the variables that could not be saved and are
+	// missing from later executions
+	Unrestorable []string `json:"unrestorable,omitempty"`
+}
+
+// ErrorCode classifies a failed request. Codes are stable, so clients
+// should act on them rather than on messages.
+type ErrorCode string
+
+const (
+	// CodeInvalidRequest means the request is malformed or names something
+	// that cannot be used; sending it again will fail again
+	CodeInvalidRequest ErrorCode = "invalid_request"
+	// CodeSessionNotFound means the session does not exist, has expired or
+	// belongs to another tenant
+	CodeSessionNotFound ErrorCode = "session_not_found"
+	// CodeTimeout means the execution ran longer than it may
+	CodeTimeout ErrorCode = "timeout"
+	// CodeLimitExceeded means a rate limit, quota or capacity limit was hit;
+	// the request may succeed later
+	CodeLimitExceeded ErrorCode = "limit_exceeded"
+	// CodePolicyViolation means the caller is not authenticated or not
+	// allowed to do what it asked for
+	CodePolicyViolation ErrorCode = "policy_violation"
+	// CodeInternal means the server failed to handle a valid request
+	CodeInternal ErrorCode = "internal"
+)
+
+// Error describes why a request failed
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Error returns the code and message, so clients can use it as an error
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// ErrorResponse is the body of every failed request. ID is the session the
+// request was about, if known.
+type ErrorResponse struct {
+	ID    string `json:"id,omitempty"`
+	Error *Error `json:"error"`
+}
+
+// CreateSessionRequest represents a request to create a session explicitly
+type CreateSessionRequest struct {
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+	MaxLifetime int `json:"max_lifetime,omitempty"`
+	// Requirements are installed into a virtual environment for the session
+	// before it is returned
+	Requirements []string `json:"requirements,omitempty"`
+}
+
+// CreateSessionResponse describes a newly created session
+type CreateSessionResponse struct {
+	SessionInfo
+	InstallOutput string `json:"install_output,omitempty"`
+	// Error is set when the requirements could not be installed and the
+	// session was discarded
+	Error *Error `json:"error,omitempty"`
+}
+
+// InstallRequest represents a request to install packages into a session
+type InstallRequest struct {
+	Packages []string `json:"packages"`
+}
+
+// SessionInfo describes a session
+type SessionInfo struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+	LastUsed  string `json:"last_used"`
+	ExpiresAt string `json:"expires_at"`
+	DiskUsage int64  `json:"disk_usage"`
+	DiskQuota int64  `json:"disk_quota,omitempty"`
+	Venv      bool   `json:"venv,omitempty"`
+}
+
+// SessionListResponse lists the sessions of the caller
+type SessionListResponse struct {
+	Tenant   string        `json:"tenant,omitempty"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// HistoryEntry represents a single past execution in a session
+type HistoryEntry struct {
+	Code       string `json:"code"`
+	StartedAt  string `json:"started_at"`
+	DurationMs int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	Status     string `json:"status"`
+}
+
+// HistoryResponse lists the executions of a session in order
+type HistoryResponse struct {
+	ID      string         `json:"id"`
+	History []HistoryEntry `json:"history"`
+}
+
+// ReplayResponse describes a session rebuilt from another session's history
+type ReplayResponse struct {
+	ID           string `json:"id"`
+	ReplayedFrom string `json:"replayed_from"`
+}
+
+// FileInfo describes a file or directory in a session workspace
+type FileInfo struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	IsDir      bool   `json:"is_dir,omitempty"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+// FileListResponse lists files in a session workspace
+type FileListResponse struct {
+	ID    string     `json:"id"`
+	Files []FileInfo `json:"files"`
+}
+
+// ConfigChange describes a setting changed by a configuration reload
+type ConfigChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	// Applied is false for settings that only take effect after a restart
+	Applied bool `json:"applied"`
+}
+
+// ReloadResponse lists the settings changed by a configuration reload
+type ReloadResponse struct {
+	Changes []ConfigChange `json:"changes"`
+}
+
+// AuditRecord describes an execution recorded in the audit log
+type AuditRecord struct {
+	Time         string `json:"time"`
+	RequestID    string `json:"request_id,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Key          string `json:"key,omitempty"`
+	ClientIP     string `json:"client_ip"`
+	SessionID    string `json:"session_id"`
+	ReplayedFrom string `json:"replayed_from,omitempty"`
+	CodeSHA256   string `json:"code_sha256"`
+	Code         string `json:"code,omitempty"`
+	Outcome      string `json:"outcome"`
+	Error        string `json:"error,omitempty"`
+	DurationMs   int64  `json:"duration_ms"`
+	CPUMs        int64  `json:"cpu_ms"`
+	WallMs       int64  `json:"wall_ms"`
+}
+
+// AuditResponse lists the audit records selected by a query, oldest first
+type AuditResponse struct {
+	Records []AuditRecord `json:"records"`
+}
+
+// HealthResponse reports the outcome of a health or readiness probe. Checks
+// maps each readiness check to "ok" or the reason it failed.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// BuildInfo describes the running server binary
+type BuildInfo struct {
+	GoVersion string `json:"go_version"`
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// StatusResponse describes the state of the server for diagnostics
+type StatusResponse struct {
+	StartedAt         string `json:"started_at"`
+	Uptime            string `json:"uptime"`
+	ActiveSessions    int    `json:"active_sessions"`
+	DiskUsage         int64  `json:"disk_usage"`
+	RunningExecutions int    `json:"running_executions"`
+	QueueDepth        int    `json:"queue_depth"`
+	// Executor and Pool hold the statistics reported by /executor and /pool
+	Executor any       `json:"executor"`
+	Pool     any       `json:"pool"`
+	Build    BuildInfo `json:"build"`
 }
@@ -0,0 +1,247 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited is returned when an account sends requests faster than
+	// its rate limit
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrCPUQuotaExceeded is returned when an account used up its CPU time
+	// for the day
+	ErrCPUQuotaExceeded = errors.New("daily CPU quota exceeded")
+	// ErrSessionQuotaExceeded is returned when an account already holds as
+	// many sessions as it may
+	ErrSessionQuotaExceeded = errors.New("session quota exceeded")
+)
+
+// Options configures the limits every account gets. Zero disables a limit.
+type Options struct {
+	// RequestsPerSecond is the rate tokens are added to an account's bucket
+	RequestsPerSecond float64
+	// Burst is the size of the bucket; zero uses RequestsPerSecond rounded
+	// up
+	Burst int
+	// DailyCPUSeconds is the CPU time an account's executions may use per
+	// UTC day
+	DailyCPUSeconds float64
+	// MaxSessions is the number of sessions an account may hold at once
+	MaxSessions int
+}
+
+// burst returns the effective bucket size
+func (o Options) burst() float64 {
+	if o.Burst > 0 {
+		return float64(o.Burst)
+	}
+	return math.Max(1, math.Ceil(o.RequestsPerSecond))
+}
+
+// Decision describes an account's bucket after a request, for the
+// RateLimit response headers
+type Decision struct {
+	// Limit is the bucket size; zero when rate limiting is disabled
+	Limit int
+	// Remaining is the number of requests that could be sent right now
+	Remaining int
+	// Reset is how long until the bucket is full again, or until the next
+	// request is allowed when it was refused
+	Reset time.Duration
+}
+
+// Usage reports an account's consumption against its limits
+type Usage struct {
+	Account           string  `json:"account"`
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+	RemainingRequests int     `json:"remaining_requests,omitempty"`
+	CPUSeconds        float64 `json:"cpu_seconds"`
+	CPUSecondsLimit   float64 `json:"cpu_seconds_limit,omitempty"`
+	CPUReset          string  `json:"cpu_reset"`
+	Sessions          int     `json:"sessions"`
+	SessionLimit      int     `json:"session_limit,omitempty"`
+}
+
+// account is the state kept per account
+type account struct {
+	tokens   float64
+	updated  time.Time
+	day      time.Time
+	cpu      time.Duration
+	sessions map[string]bool
+	pending  int
+}
+
+// Limiter applies rate limits and quotas per account, such as an API key
+// or tenant
+type Limiter struct {
+	mutex    sync.Mutex
+	opts     Options
+	accounts map[string]*account
+	alive    func(sessionID string) bool
+	now      func() time.Time
+}
+
+// New creates a limiter. alive reports whether a session still exists, so
+// that expired and deleted sessions stop counting against the quota.
+func New(opts Options, alive func(sessionID string) bool) *Limiter {
+	return &Limiter{
+		opts:     opts,
+		accounts: make(map[string]*account),
+		alive:    alive,
+		now:      time.Now,
+	}
+}
+
+// SetOptions changes the limits; usage recorded so far is kept
+func (l *Limiter) SetOptions(opts Options) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.opts = opts
+}
+
+// get returns the state of name, refilling its bucket and starting a new
+// day when due. Callers must hold l.mutex.
+func (l *Limiter) get(name string) *account {
+	now := l.now()
+	day := now.UTC().Truncate(24 * time.Hour)
+	a, ok := l.accounts[name]
+	if !ok {
+		a = &account{tokens: l.opts.burst(), updated: now, day: day, sessions: make(map[string]bool)}
+		l.accounts[name] = a
+	}
+
+	elapsed := now.Sub(a.updated).Seconds()
+	a.tokens = math.Min(l.opts.burst(), a.tokens+elapsed*l.opts.RequestsPerSecond)
+	a.updated = now
+	if day.After(a.day) {
+		a.day = day
+		a.cpu = 0
+	}
+	for id := range a.sessions {
+		if !l.alive(id) {
+			delete(a.sessions, id)
+		}
+	}
+	return a
+}
+
+// Allow takes a token from the bucket of name
+func (l *Limiter) Allow(name string) (Decision, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.opts.RequestsPerSecond <= 0 {
+		return Decision{}, nil
+	}
+	a := l.get(name)
+	burst := l.opts.burst()
+	decision := Decision{Limit: int(burst)}
+
+	if a.tokens < 1 {
+		decision.Reset = l.seconds(1 - a.tokens)
+		return decision, ErrRateLimited
+	}
+	a.tokens--
+	decision.Remaining = int(a.tokens)
+	decision.Reset = l.seconds(burst - a.tokens)
+	return decision, nil
+}
+
+// seconds returns how long refilling the given number of tokens takes
+func (l *Limiter) seconds(tokens float64) time.Duration {
+	return time.Duration(tokens / l.opts.RequestsPerSecond * float64(time.Second))
+}
+
+// CheckCPU reports whether name has CPU time left today
+func (l *Limiter) CheckCPU(name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.opts.DailyCPUSeconds <= 0 {
+		return nil
+	}
+	if l.get(name).cpu.Seconds() >= l.opts.DailyCPUSeconds {
+		return ErrCPUQuotaExceeded
+	}
+	return nil
+}
+
+// AddCPU charges CPU time used by an execution to name
+func (l *Limiter) AddCPU(name string, cpu time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.get(name).cpu += cpu
+}
+
+// CPUReset returns when the daily CPU quota starts over
+func (l *Limiter) CPUReset() time.Time {
+	return l.now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// ReserveSession makes room for a new session of name. The returned
+// function must be called with the ID of the created session, or an empty
+// ID if creation failed, to settle the reservation.
+func (l *Limiter) ReserveSession(name string) (func(sessionID string), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	a := l.get(name)
+	if l.opts.MaxSessions > 0 && len(a.sessions)+a.pending >= l.opts.MaxSessions {
+		return nil, ErrSessionQuotaExceeded
+	}
+	a.pending++
+
+	var once sync.Once
+	return func(sessionID string) {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			a.pending--
+			if sessionID != "" {
+				a.sessions[sessionID] = true
+			}
+		})
+	}, nil
+}
+
+// Prune forgets the accounts that are back in their initial state: a full
+// bucket, no CPU time used today and no sessions. They start over from that
+// state on their next request, so forgetting them only frees memory.
+func (l *Limiter) Prune() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for name := range l.accounts {
+		a := l.get(name)
+		if a.tokens >= l.opts.burst() && a.cpu == 0 && len(a.sessions) == 0 && a.pending == 0 {
+			delete(l.accounts, name)
+		}
+	}
+}
+
+// Usage reports the consumption of name
+func (l *Limiter) Usage(name string) Usage {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	a := l.get(name)
+	usage := Usage{
+		Account:         name,
+		CPUSeconds:      a.cpu.Seconds(),
+		CPUSecondsLimit: l.opts.DailyCPUSeconds,
+		CPUReset:        a.day.Add(24 * time.Hour).Format(time.RFC3339),
+		Sessions:        len(a.sessions),
+		SessionLimit:    l.opts.MaxSessions,
+	}
+	if l.opts.RequestsPerSecond > 0 {
+		usage.RequestsPerSecond = l.opts.RequestsPerSecond
+		usage.Burst = int(l.opts.burst())
+		usage.RemainingRequests = int(a.tokens)
+	}
+	return usage
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with a clock the test advances
+func newTestLimiter(opts Options, alive func(string) bool) (*Limiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	l := New(opts, alive)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, now := newTestLimiter(Options{RequestsPerSecond: 2, Burst: 3}, nil)
+
+	for i := 0; i < 3; i++ {
+		decision, err := l.Allow("alice")
+		if err != nil {
+			t.Fatalf("Expected request %d to be allowed, got %v", i+1, err)
+		}
+		if decision.Limit != 3 || decision.Remaining != 2-i {
+			t.Fatalf("Unexpected decision for request %d: %+v", i+1, decision)
+		}
+	}
+
+	decision, err := l.Allow("alice")
+	if err != ErrRateLimited || decision.Reset != 500*time.Millisecond {
+		t.Fatalf("Expected rate limit with reset in 500ms, got %+v, %v", decision, err)
+	}
+
+	// Accounts have their own buckets
+	if _, err := l.Allow("bob"); err != nil {
+		t.Fatalf("Expected another account to be allowed, got %v", err)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if _, err := l.Allow("alice"); err != nil {
+		t.Fatalf("Expected a refilled token, got %v", err)
+	}
+}
+
+func TestCPUQuota(t *testing.T) {
+	l, now := newTestLimiter(Options{DailyCPUSeconds: 10}, nil)
+
+	l.AddCPU("alice", 9*time.Second)
+	if err := l.CheckCPU("alice"); err != nil {
+		t.Fatalf("Expected CPU time left, got %v", err)
+	}
+	l.AddCPU("alice", 2*time.Second)
+	if err := l.CheckCPU("alice"); err != ErrCPUQuotaExceeded {
+		t.Fatalf("Expected CPU quota to be exceeded, got %v", err)
+	}
+	if usage := l.Usage("alice"); usage.CPUSeconds != 11 || usage.CPUReset != "2024-01-02T00:00:00Z" {
+		t.Fatalf("Unexpected usage: %+v", usage)
+	}
+
+	// The quota starts over at midnight UTC
+	*now = now.Add(time.Hour)
+	if err := l.CheckCPU("alice"); err != nil {
+		t.Fatalf("Expected the quota to reset, got %v", err)
+	}
+}
+
+func TestSessionQuota(t *testing.T) {
+	alive := map[string]bool{}
+	l, _ := newTestLimiter(Options{MaxSessions: 2}, func(id string) bool { return alive[id] })
+
+	commit, err := l.ReserveSession("alice")
+	if err != nil {
+		t.Fatalf("Failed to reserve session: %v", err)
+	}
+	alive["s1"] = true
+	commit("s1")
+
+	// A pending reservation counts until it is settled
+	commit, err = l.ReserveSession("alice")
+	if err != nil {
+		t.Fatalf("Failed to reserve session: %v", err)
+	}
+	if _, err := l.ReserveSession("alice"); err != ErrSessionQuotaExceeded {
+		t.Fatalf("Expected session quota to be exceeded, got %v", err)
+	}
+	commit("")
+
+	alive["s2"] = true
+	commit, _ = l.ReserveSession("alice")
+	commit("s2")
+	if _, err := l.ReserveSession("alice"); err != ErrSessionQuotaExceeded {
+		t.Fatalf("Expected session quota to be exceeded, got %v", err)
+	}
+
+	// Sessions that are gone free their slot
+	delete(alive, "s1")
+	if _, err := l.ReserveSession("alice"); err != nil {
+		t.Fatalf("Expected a free slot after a session ended, got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	alive := map[string]bool{}
+	l, now := newTestLimiter(Options{RequestsPerSecond: 1, DailyCPUSeconds: 10}, func(id string) bool { return alive[id] })
+
+	l.Allow("ip:10.0.0.1")
+	l.AddCPU("alice", time.Second)
+	commit, _ := l.ReserveSession("bob")
+	alive["s1"] = true
+	commit("s1")
+
+	// Accounts are kept while they have anything to remember
+	l.Prune()
+	if len(l.accounts) != 3 {
+		t.Fatalf("Expected 3 accounts, got %d", len(l.accounts))
+	}
+
+	// A refilled bucket, a new day and an ended session leave nothing
+	*now = now.Add(time.Hour)
+	delete(alive, "s1")
+	l.Prune()
+	if len(l.accounts) != 0 {
+		t.Fatalf("Expected every account to be pruned, got %d", len(l.accounts))
+	}
+}
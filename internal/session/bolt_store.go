@@ -0,0 +1,171 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metadataBucket = []byte("metadata")
+	stateBucket    = []byte("state")
+	historyBucket  = []byte("history")
+)
+
+// BoltStore keeps session metadata and state in an embedded bbolt database
+// file, so no external service is needed
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the database at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metadataBucket, stateBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize session database: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// SaveMetadata stores the metadata of a session
+func (b *BoltStore) SaveMetadata(meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode session metadata: %v", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).Put([]byte(meta.Key()), data)
+	})
+}
+
+// LoadMetadata returns the stored metadata of a session
+func (b *BoltStore) LoadMetadata(id string) (Metadata, error) {
+	var meta Metadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(metadataBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &meta)
+	})
+	return meta, err
+}
+
+// ListMetadata returns the metadata of every stored session
+func (b *BoltStore) ListMetadata() ([]Metadata, error) {
+	var metas []Metadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(_, data []byte) error {
+			var meta Metadata
+			if err := json.Unmarshal(data, &meta); err != nil {
+				// Skip corrupt records rather than failing the whole listing
+				return nil
+			}
+			metas = append(metas, meta)
+			return nil
+		})
+	})
+	return metas, err
+}
+
+// SaveState stores the serialized Python state of a session
+func (b *BoltStore) SaveState(id string, state []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put([]byte(id), state)
+	})
+}
+
+// LoadState returns the serialized Python state of a session
+func (b *BoltStore) LoadState(id string) ([]byte, error) {
+	var state []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(stateBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		// bbolt values are only valid for the life of the transaction
+		state = append([]byte(nil), data...)
+		return nil
+	})
+	return state, err
+}
+
+// AppendHistory stores an execution in the per-session history bucket, keyed
+// by a sequence number so entries iterate in execution order
+func (b *BoltStore) AppendHistory(id string, entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %v", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, data)
+	})
+}
+
+// LoadHistory returns the stored executions of a session in order
+func (b *BoltStore) LoadHistory(id string) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var entry HistoryEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("failed to decode history entry: %v", err)
+			}
+			history = append(history, entry)
+			return nil
+		})
+	})
+	return history, err
+}
+
+// Delete removes the metadata, state and history of a session
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metadataBucket, stateBucket} {
+			if err := tx.Bucket(name).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(historyBucket).DeleteBucket([]byte(id)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+// Close closes the underlying database
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,30 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// CheckInterpreter verifies that the configured Python interpreter starts
+// and runs code
+func (m *Manager) CheckInterpreter(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, m.python, "-c", "print('ok')").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %v", m.python, err)
+	}
+	if string(output) != "ok\n" {
+		return fmt.Errorf("unexpected output from %s: %q", m.python, output)
+	}
+	return nil
+}
+
+// CheckBaseDir verifies that new session directories can be created
+func (m *Manager) CheckBaseDir() error {
+	dir, err := os.MkdirTemp(m.baseDir, ".health-")
+	if err != nil {
+		return fmt.Errorf("session base directory is not writable: %v", err)
+	}
+	return os.Remove(dir)
+}
@@ -3,32 +3,194 @@ package session
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// workspaceDirName is the directory user code runs in and user files
+	// live in
+	workspaceDirName = "workspace"
+	// harnessDirName is the hidden directory next to the workspace holding
+	// the files used to run code and keep state. It is kept out of the
+	// working directory and the file API, but code in the session runs as
+	// the same OS user and can still reach it as ../.harness.
+	harnessDirName = ".harness"
+	// stateFileName holds the serialized Python variables of a session
+	stateFileName = "session_state.py"
+	// metadataFileName marks a session directory as restorable after a restart
+	metadataFileName = "session.json"
+	// historyFileName holds one JSON line per execution in the session
+	historyFileName = "history.jsonl"
+	// unrestorableMarker prefixes state lines for variables that could not
+	// be saved in any form
+	unrestorableMarker = "# unrestorable: "
+	// defaultPython is the interpreter used unless another one is configured
+	defaultPython = "python3"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session does not exist or has expired
+	ErrSessionNotFound = errors.New("session not found or expired")
+	// ErrInvalidSessionID is returned for IDs that are unsafe to use as
+	// directory names
+	ErrInvalidSessionID = errors.New("invalid session ID")
+)
+
+// validID restricts session IDs to a single path element of safe characters
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// ValidateID reports whether id can be used as a session ID
+func ValidateID(id string) error {
+	if !validID.MatchString(id) {
+		return ErrInvalidSessionID
+	}
+	return nil
+}
+
 // Session represents a Python code execution environment with persistence
 type Session struct {
 	ID         string
+	tenant     string
+	key        string
 	sessionDir string
+	workDir    string
+	harnessDir string
 	statePath  string
-	lastUsed   time.Time
+	store      Store
+	manager    *Manager
+	diskUsage  int64
+	createdAt  time.Time
+	lastUsed   atomic.Int64 // UnixNano, read without holding mutex
+	lifetime   Lifetime
+	owner      string
 	mutex      sync.Mutex
 	isRunning  bool
 }
 
-// Manager handles the creation and management of interpreter sessions
+// Lifetime bounds how long a session is kept
+type Lifetime struct {
+	// IdleTimeout expires the session after this long without executions.
+	// Zero uses the limit passed to CleanupSessions.
+	IdleTimeout time.Duration
+	// MaxLifetime expires the session this long after creation regardless
+	// of use. Zero means no absolute limit.
+	MaxLifetime time.Duration
+}
+
+// CreateOptions configures a newly created session
+type CreateOptions struct {
+	Lifetime Lifetime
+	// Owner identifies who created the session, such as an API key ID.
+	// Empty means the session is not bound to anyone.
+	Owner string
+}
+
+// ExecuteOptions controls a single execution
+type ExecuteOptions struct {
+	// Timeout limits how long the interpreter may run. Waiting for the
+	// session and for an execution slot does not count. Zero means the
+	// execution is only bounded by its context.
+	Timeout time.Duration
+	// MemoryLimit caps the address space of the interpreter in bytes. Zero
+	// means no limit.
+	MemoryLimit int64
+}
+
+// Usage describes the resources an execution consumed
+type Usage struct {
+	// CPUTime is the user and system CPU time of the interpreter. Pooled
+	// interpreters also count the time spent on their preload script.
+	CPUTime time.Duration
+	// WallTime is how long the interpreter ran
+	WallTime time.Duration
+}
+
+// Result is the output of an execution and the resources it used
+type Result struct {
+	Stdout string
+	Stderr string
+	Usage  Usage
+	// Unrestorable names the variables that could not be saved, such as
+	// lambdas and generators. Later executions do not have them.
+	Unrestorable []string
+}
+
+// processUsage measures an interpreter that was started at startedAt and
+// has exited
+func processUsage(state *os.ProcessState, startedAt time.Time) Usage {
+	usage := Usage{WallTime: time.Since(startedAt)}
+	if state != nil {
+		usage.CPUTime = state.UserTime() + state.SystemTime()
+	}
+	return usage
+}
+
+// Limiter admits executions into a bounded number of interpreter slots
+type Limiter interface {
+	// Acquire waits for a slot and returns the function that frees it
+	Acquire(ctx context.Context) (release func(), err error)
+}
+
+// Manager handles the creation and management of interpreter sessions.
+//
+// Lock order: a session mutex is never held while taking m.mutex. Code that
+// runs under a session mutex, such as executions, reaches the pool, limiter
+// and observer through atomic pointers instead, and code holding m.mutex
+// only takes session mutexes with TryLock or after releasing it.
 type Manager struct {
-	sessions map[string]*Session
-	mutex    sync.RWMutex
-	baseDir  string
+	sessions   map[string]*Session
+	mutex      sync.RWMutex
+	baseDir    string
+	python     string
+	store      Store
+	quota      DiskQuota
+	wheelhouse string
+	pool       atomic.Pointer[pool]
+	limiter    atomic.Pointer[Limiter]
+	observer   atomic.Pointer[ExecutionObserver]
+	tenantMax  int
+	created    atomic.Int64
+	expired    atomic.Int64
+	evicted    atomic.Int64
+}
+
+// Options configures a Manager
+type Options struct {
+	// BaseDir is the directory holding one working directory per session.
+	// Defaults to python-sessions under the system temp directory.
+	BaseDir string
+	// PythonPath is the interpreter sessions run with until they get their
+	// own virtual environment. Defaults to python3 on the PATH.
+	PythonPath string
+	// Store persists session metadata and state. Defaults to a FileStore
+	// rooted at BaseDir.
+	Store Store
+	// DiskQuota limits the disk space used by sessions. Defaults to no limit.
+	DiskQuota DiskQuota
+	// Wheelhouse is the local directory of wheels sessions may install
+	// packages from. Defaults to none, which disables installation.
+	Wheelhouse string
+	// Pool keeps interpreters started ahead of time. Defaults to no pool.
+	Pool PoolOptions
+	// Limiter bounds the number of interpreters running at once. Defaults
+	// to no limit.
+	Limiter Limiter
+	// TenantSessionLimit caps the number of sessions of each tenant.
+	// Defaults to no limit.
+	TenantSessionLimit int
 }
 
 // NewManager creates a new session manager
@@ -40,15 +202,97 @@ func NewManager() *Manager {
 	return &Manager{
 		sessions: make(map[string]*Session),
 		baseDir:  baseDir,
+		python:   defaultPython,
+		store:    NewFileStore(baseDir),
+	}
+}
+
+// NewManagerWithOptions creates a session manager with a custom base
+// directory and store
+func NewManagerWithOptions(opts Options) (*Manager, error) {
+	baseDir := opts.BaseDir
+	if baseDir == "" {
+		baseDir = filepath.Join(os.TempDir(), "python-sessions")
+	}
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create session base directory: %v", err)
+	}
+
+	store := opts.Store
+	if store == nil {
+		store = NewFileStore(baseDir)
+	}
+
+	python := opts.PythonPath
+	if python == "" {
+		python = defaultPython
+	}
+
+	manager := &Manager{
+		sessions:   make(map[string]*Session),
+		baseDir:    baseDir,
+		python:     python,
+		store:      store,
+		quota:      opts.DiskQuota,
+		wheelhouse: opts.Wheelhouse,
+		tenantMax:  opts.TenantSessionLimit,
+	}
+	manager.SetLimiter(opts.Limiter)
+	manager.ConfigurePool(opts.Pool)
+	return manager, nil
+}
+
+// SetLimiter changes the limiter executions are admitted through; nil
+// removes the limit
+func (m *Manager) SetLimiter(limiter Limiter) {
+	if limiter == nil {
+		m.limiter.Store(nil)
+		return
+	}
+	m.limiter.Store(&limiter)
+}
+
+// admit waits for an execution slot and returns the function that frees it
+func (m *Manager) admit(ctx context.Context) (func(), error) {
+	limiter := m.limiter.Load()
+	if limiter == nil {
+		return func() {}, nil
 	}
+	return (*limiter).Acquire(ctx)
+}
+
+// Close stops the interpreter pool and releases the session store
+func (m *Manager) Close() error {
+	m.ConfigurePool(PoolOptions{})
+	return m.store.Close()
 }
 
 // GetOrCreateSession retrieves an existing session or creates a new one
 func (m *Manager) GetOrCreateSession(id string) (*Session, error) {
+	return m.GetOrCreateSessionWithOptions(id, CreateOptions{})
+}
+
+// GetOrCreateSessionWithOptions retrieves an existing session or creates a
+// new one configured by opts. The options are ignored for existing sessions.
+func (m *Manager) GetOrCreateSessionWithOptions(id string, opts CreateOptions) (*Session, error) {
+	return m.getOrCreateSession("", id, opts)
+}
+
+// getOrCreateSession retrieves an existing session of tenant or creates a
+// new one
+func (m *Manager) getOrCreateSession(tenant, id string, opts CreateOptions) (*Session, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+
 	// If ID is provided, try to get existing session
 	if id != "" {
+		if err := ValidateID(id); err != nil {
+			return nil, err
+		}
+
 		m.mutex.RLock()
-		session, exists := m.sessions[id]
+		session, exists := m.sessions[sessionKey(tenant, id)]
 		m.mutex.RUnlock()
 
 		if exists && session.isRunning {
@@ -57,103 +301,590 @@ func (m *Manager) GetOrCreateSession(id string) (*Session, error) {
 	}
 
 	// Create a new session with the provided ID (or generate one if empty)
-	return m.createNewSession(id)
+	return m.createNewSession(tenant, id, opts)
+}
+
+// CreateSession creates a new session with a server-generated ID
+func (m *Manager) CreateSession(opts CreateOptions) (*Session, error) {
+	return m.createNewSession("", "", opts)
+}
+
+// GetSession retrieves an existing session without creating one
+func (m *Manager) GetSession(id string) (*Session, error) {
+	return m.getSession("", id)
+}
+
+// getSession retrieves an existing session of tenant
+func (m *Manager) getSession(tenant, id string) (*Session, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	session, exists := m.sessions[sessionKey(tenant, id)]
+	m.mutex.RUnlock()
+
+	if !exists || !session.isRunning {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
 }
 
-// createNewSession initializes a new Python session
-func (m *Manager) createNewSession(providedID string) (*Session, error) {
+// ReplaySession creates a new session whose namespace is rebuilt by
+// re-executing the successful history of an existing session in a single
+// run limited by opts. The history is copied to the new session; the
+// original session is left untouched. A run that exceeds the timeout returns
+// context.DeadlineExceeded. The resources the run used are reported even
+// when it fails.
+func (m *Manager) ReplaySession(ctx context.Context, id string, opts ExecuteOptions) (*Session, Usage, error) {
+	return m.replaySession(ctx, "", id, opts)
+}
+
+// replaySession replays a session of tenant into a new session of the same
+// tenant
+func (m *Manager) replaySession(ctx context.Context, tenant, id string, opts ExecuteOptions) (*Session, Usage, error) {
+	source, err := m.getSession(tenant, id)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	history, err := source.History()
+	if err != nil {
+		return nil, Usage{}, fmt.Errorf("failed to load session history: %v", err)
+	}
+
+	replayed, err := m.createNewSession(tenant, "", CreateOptions{Lifetime: source.lifetime, Owner: source.owner})
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	replayed.mutex.Lock()
+	usage, err := replayed.rebuild(ctx, history, opts)
+	replayed.mutex.Unlock()
+
+	if err != nil {
+		m.removeSession(replayed)
+		return nil, usage, err
+	}
+	return replayed, usage, nil
+}
+
+// rebuild copies the given history into the session and replays it to
+// recreate the namespace. Callers must hold s.mutex.
+func (s *Session) rebuild(ctx context.Context, history []HistoryEntry, opts ExecuteOptions) (Usage, error) {
+	for _, entry := range history {
+		if err := s.store.AppendHistory(s.key, entry); err != nil {
+			return Usage{}, fmt.Errorf("failed to copy session history: %v", err)
+		}
+	}
+
+	codes, err := s.replayCodes()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	release, err := s.manager.admit(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+	defer release()
+
+	// The timeout covers only the interpreter run
+	runCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// Run no new code: the wrapper replays the history and saves the state.
+	// The replay counts as one execution.
+	result, err := s.run(runCtx, "", codes, opts.MemoryLimit)
+	if runCtx.Err() == context.DeadlineExceeded {
+		s.manager.observeExecution(StatusTimeout, result.Usage.WallTime)
+		return result.Usage, runCtx.Err()
+	}
+	if err != nil {
+		s.manager.observeExecution(StatusError, result.Usage.WallTime)
+		return result.Usage, fmt.Errorf("failed to replay session history: %v", err)
+	}
+	s.manager.observeExecution(StatusOK, result.Usage.WallTime)
+
+	if _, err := s.saveState(); err != nil {
+		return result.Usage, err
+	}
+	return result.Usage, s.saveMetadata()
+}
+
+// DeleteSession removes a session and its files
+func (m *Manager) DeleteSession(id string) error {
+	return m.deleteSession("", id)
+}
+
+// deleteSession removes a session of tenant and its files
+func (m *Manager) deleteSession(tenant, id string) error {
+	session, err := m.getSession(tenant, id)
+	if err != nil {
+		return err
+	}
+	m.removeSession(session)
+	return nil
+}
+
+// removeSession cleans up a session and forgets it
+func (m *Manager) removeSession(session *Session) {
+	session.Cleanup()
+
+	m.mutex.Lock()
+	delete(m.sessions, session.key)
+	m.mutex.Unlock()
+}
+
+// newSession builds a session and creates its workspace and harness
+// directories
+func (m *Manager) newSession(tenant, id string, createdAt, lastUsed time.Time, opts CreateOptions) (*Session, error) {
+	key := sessionKey(tenant, id)
+	sessionDir := filepath.Join(m.baseDir, key)
+	session := &Session{
+		ID:         id,
+		tenant:     tenant,
+		key:        key,
+		sessionDir: sessionDir,
+		workDir:    filepath.Join(sessionDir, workspaceDirName),
+		harnessDir: filepath.Join(sessionDir, harnessDirName),
+		statePath:  filepath.Join(sessionDir, harnessDirName, stateFileName),
+		store:      m.store,
+		manager:    m,
+		createdAt:  createdAt,
+		lifetime:   opts.Lifetime,
+		owner:      opts.Owner,
+		isRunning:  true,
+	}
+
+	session.setLastUsed(lastUsed)
+
+	for _, dir := range []string{session.workDir, session.harnessDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create session directory: %v", err)
+		}
+	}
+	return session, nil
+}
+
+// createNewSession initializes a new Python session of tenant
+func (m *Manager) createNewSession(tenant, providedID string, opts CreateOptions) (*Session, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
 	sessionID := providedID
 	if sessionID == "" {
 		sessionID = uuid.New().String()
 	}
+	key := sessionKey(tenant, sessionID)
 
-	// Create a directory for this session
-	sessionDir := filepath.Join(m.baseDir, sessionID)
-	if err := os.MkdirAll(sessionDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create session directory: %v", err)
+	// Fail early rather than creating directories only to remove them
+	m.mutex.RLock()
+	full := m.tenantFull(tenant, key)
+	m.mutex.RUnlock()
+	if full {
+		return nil, ErrTenantSessionLimit
 	}
 
-	// Create a state file path for this session
-	statePath := filepath.Join(sessionDir, "session_state.py")
+	// Create the directories for this session
+	now := time.Now()
+	session, err := m.newSession(tenant, sessionID, now, now, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create the initial state file
-	if err := os.WriteFile(statePath, []byte("# Python session state file\n"), 0644); err != nil {
+	// Create the initial state
+	if err := m.store.SaveState(key, []byte("# Python session state file\n")); err != nil {
 		return nil, fmt.Errorf("failed to initialize session state: %v", err)
 	}
 
-	session := &Session{
-		ID:         sessionID,
-		sessionDir: sessionDir,
-		statePath:  statePath,
-		lastUsed:   time.Now(),
-		isRunning:  true,
+	if err := session.saveMetadata(); err != nil {
+		return nil, err
 	}
 
+	// Check again now that the session is registered, since other sessions
+	// of the tenant may have been created in the meantime
 	m.mutex.Lock()
-	m.sessions[sessionID] = session
+	if m.tenantFull(tenant, key) {
+		m.mutex.Unlock()
+		session.Cleanup()
+		return nil, ErrTenantSessionLimit
+	}
+	m.sessions[key] = session
 	m.mutex.Unlock()
 
+	m.created.Add(1)
 	return session, nil
 }
 
+// wrapperScript surrounds the submitted code. It makes the code look like a
+// main.py in the workspace, restores the session namespace, either from the
+// saved state or, when rebuilding a replayed session, by silently
+// re-executing earlier code, and saves the namespace again afterwards.
+// Values are saved as their repr when it reads back as a literal, top-level
+// functions and classes as their source and anything else pickled; what
+// cannot be saved either way is recorded as unrestorable.
+const wrapperScript = `
+import ast as __ast, contextlib as __contextlib, io as __io, json as __json, os as __os, sys as __sys
+
+# Cap the address space before any user code runs; the hard limit cannot be
+# raised again by unprivileged code
+if %[6]d > 0:
+    import resource as __resource
+    __resource.setrlimit(__resource.RLIMIT_AS, (%[6]d, %[6]d))
+
+# Run as main.py in the workspace
+__os.chdir(%[1]q)
+__file__ = __os.path.join(%[1]q, "main.py")
+__sys.argv = ["main.py"]
+__sys.path[0] = %[1]q
+
+# The source of the top-level functions and classes defined so far
+__sources = {}
+
+def __collect(source):
+    try:
+        tree = __ast.parse(source)
+    except SyntaxError:
+        return
+    lines = source.splitlines(True)
+    for node in tree.body:
+        if isinstance(node, (__ast.FunctionDef, __ast.AsyncFunctionDef, __ast.ClassDef)):
+            first = node.decorator_list[0].lineno if node.decorator_list else node.lineno
+            __sources[node.name] = "".join(lines[first - 1:node.end_lineno])
+
+# Restore a saved function or class, or a pickled value
+def __define(name, source):
+    try:
+        exec(source, globals())
+        __sources[name] = source
+    except Exception:
+        pass
+
+def __unpickle(name, data):
+    import base64, pickle
+    try:
+        globals()[name] = pickle.loads(base64.b64decode(data))
+    except Exception:
+        pass
+
+# Import the session state
+with __contextlib.redirect_stdout(__io.StringIO()), __contextlib.redirect_stderr(__io.StringIO()):
+    if %[2]q and __os.path.exists(%[2]q):
+        for __code in __json.load(open(%[2]q, encoding="utf-8")):
+            try:
+                exec(__code, globals())
+                __collect(__code)
+            except BaseException:
+                pass  # Ignore errors when replaying history
+    else:
+        try:
+            exec(open(%[3]q).read())
+        except Exception as e:
+            pass  # Ignore errors when loading state
+
+# Execute the provided code, reporting errors without the wrapper frame
+def __run(path):
+    import linecache, traceback
+    with open(path, encoding="utf-8") as code_file:
+        source = code_file.read()
+    linecache.cache[__file__] = (len(source), None, source.splitlines(True), __file__)
+    try:
+        exec(compile(source, __file__, "exec"), globals())
+    except SystemExit:
+        raise
+    except BaseException as error:
+        traceback.print_exception(type(error), error, error.__traceback__.tb_next)
+        __sys.exit(1)
+    __collect(source)
+
+__run(%[4]q)
+
+# Save important variables to session state. Functions and classes come
+# first so that pickled instances of them can be loaded.
+def __save_state(namespace):
+    import base64, inspect, pickle
+    definitions, values = [], []
+    for name, value in list(namespace.items()):
+        if name.startswith("_") or inspect.ismodule(value):
+            continue
+        try:
+            text = repr(value)
+            __ast.literal_eval(text)
+            values.append("{} = {}\n".format(name, text))
+            continue
+        except Exception:
+            pass
+        if (inspect.isfunction(value) or inspect.isclass(value)) and value.__module__ == "__main__" and value.__qualname__ == name and name in __sources:
+            definitions.append("__define({!r}, {!r})\n".format(name, __sources[name]))
+            continue
+        try:
+            data = base64.b64encode(pickle.dumps(value)).decode()
+            values.append("__unpickle({!r}, {!r})\n".format(name, data))
+        except Exception:
+            values.append("%[5]s{}\n".format(name))
+    with open(%[3]q, "w") as state_file:
+        state_file.write("# Python session state file\n")
+        state_file.writelines(definitions + values)
+
+__save_state(globals())
+`
+
 // ExecuteCode runs Python code within the given session
 func (s *Session) ExecuteCode(ctx context.Context, code string) (string, string, error) {
+	return s.ExecuteCodeWithOptions(ctx, code, ExecuteOptions{})
+}
+
+// ExecuteCodeWithOptions runs Python code in the session once the session
+// is free and the limiter admits it. A run that exceeds the timeout returns
+// context.DeadlineExceeded.
+func (s *Session) ExecuteCodeWithOptions(ctx context.Context, code string, opts ExecuteOptions) (string, string, error) {
+	result, err := s.Execute(ctx, code, opts)
+	return result.Stdout, result.Stderr, err
+}
+
+// Execute runs Python code like ExecuteCodeWithOptions and also reports the
+// resources the interpreter used, including for runs that time out
+func (s *Session) Execute(ctx context.Context, code string, opts ExecuteOptions) (Result, error) {
+	// Enforce the disk quota before taking the session lock, since eviction
+	// locks other sessions
+	if err := s.manager.checkQuota(s); err != nil {
+		return Result{}, err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	if !s.isRunning {
-		return "", "", errors.New("session is no longer running")
+		return Result{}, errors.New("session is no longer running")
+	}
+
+	// Wait for an interpreter slot
+	_, span := tracer().Start(ctx, "session.admit")
+	release, err := s.manager.admit(ctx)
+	endSpan(span, err)
+	if err != nil {
+		return Result{}, err
 	}
+	defer release()
 
 	// Update last used time
-	s.lastUsed = time.Now()
+	s.setLastUsed(time.Now())
+
+	// Materialize the stored state for the interpreter. The history is
+	// only re-executed when the session is replayed explicitly.
+	_, span = tracer().Start(ctx, "session.load_state")
+	_, err = s.loadState()
+	endSpan(span, err)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// The timeout covers only the interpreter run
+	runCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	runCtx, span = tracer().Start(runCtx, "session.execute")
+	startedAt := time.Now()
+	result, err := s.run(runCtx, code, nil, opts.MemoryLimit)
+	endSpan(span, err)
+
+	// Persist the new state, history and last used time so the session can
+	// be restored after a restart
+	_, span = tracer().Start(ctx, "session.save_state")
+	if state, err := s.saveState(); err == nil {
+		result.Unrestorable = unrestorableNames(state)
+	}
+	s.recordHistory(runCtx, code, startedAt, result.Stdout, result.Stderr, err)
+	s.saveMetadata()
+
+	// Measure what the execution left on disk for the next quota check
+	s.measureDisk()
+	span.End()
+
+	// Special handling for timeout
+	if runCtx.Err() == context.DeadlineExceeded {
+		return Result{Usage: result.Usage, Unrestorable: result.Unrestorable}, runCtx.Err()
+	}
+
+	return result, err
+}
+
+// run executes code in a fresh interpreter, replaying the given history first
+// when it is non-empty and limiting its memory when memoryLimit is
+// positive. Callers must hold s.mutex.
+func (s *Session) run(ctx context.Context, code string, replay []string, memoryLimit int64) (Result, error) {
+	replayPath := ""
+	if len(replay) > 0 {
+		data, err := json.Marshal(replay)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to encode replay history: %v", err)
+		}
+		replayPath = filepath.Join(s.harnessDir, fmt.Sprintf("replay_%d.json", time.Now().UnixNano()))
+		if err := os.WriteFile(replayPath, data, 0644); err != nil {
+			return Result{}, fmt.Errorf("failed to create replay file: %v", err)
+		}
+		defer os.Remove(replayPath)
+	}
+
+	// Keep the code in its own file so it is compiled as written
+	codePath := filepath.Join(s.harnessDir, fmt.Sprintf("code_%d.py", time.Now().UnixNano()))
+	if err := os.WriteFile(codePath, []byte(code), 0644); err != nil {
+		return Result{}, fmt.Errorf("failed to create code file: %v", err)
+	}
+	defer os.Remove(codePath)
 
 	// Create a temporary script file that imports the session state
-	tempScriptPath := filepath.Join(s.sessionDir, fmt.Sprintf("exec_%d.py", time.Now().UnixNano()))
-	scriptContent := fmt.Sprintf(`
-# Import the session state
-try:
-    exec(open(%q).read())
-except Exception as e:
-    pass  # Ignore errors when loading state
-
-# Execute the provided code
-%s
-
-# Save important variables to session state
-import inspect, sys
-with open(%q, "w") as state_file:
-    state_file.write("# Python session state file\n")
-    for name, value in list(locals().items()):
-        if not name.startswith("_") and name != "state_file" and not inspect.ismodule(value):
-            try:
-                state_file.write("{} = {!r}\n".format(name, value))
-            except:
-                pass
-`, s.statePath, code, s.statePath)
+	tempScriptPath := filepath.Join(s.harnessDir, fmt.Sprintf("exec_%d.py", time.Now().UnixNano()))
+	scriptContent := fmt.Sprintf(wrapperScript, s.workDir, replayPath, s.statePath, codePath, unrestorableMarker, memoryLimit)
 
 	if err := os.WriteFile(tempScriptPath, []byte(scriptContent), 0644); err != nil {
-		return "", "", fmt.Errorf("failed to create execution script: %v", err)
+		return Result{}, fmt.Errorf("failed to create execution script: %v", err)
 	}
 
 	// Ensure we clean up the temporary script after execution
 	defer os.Remove(tempScriptPath)
 
+	// Prefer a pre-started interpreter when using the system interpreter
+	span := trace.SpanFromContext(ctx)
+	if !s.HasVenv() {
+		if result, ran, err := s.manager.runPooled(ctx, tempScriptPath); ran {
+			span.SetAttributes(attribute.Bool("pyexec.warm_pool", true))
+			return result, err
+		}
+	}
+	span.SetAttributes(attribute.Bool("pyexec.warm_pool", false))
+
 	// Execute the script
-	cmd := exec.CommandContext(ctx, "python3", tempScriptPath)
+	cmd := exec.CommandContext(ctx, s.pythonPath(), tempScriptPath)
+	cmd.Dir = s.workDir
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	_, spawn := tracer().Start(ctx, "session.spawn_process")
+	startedAt := time.Now()
+	err := cmd.Start()
+	endSpan(spawn, err)
+	if err != nil {
+		return Result{}, err
+	}
+	err = cmd.Wait()
+	return Result{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Usage:  processUsage(cmd.ProcessState, startedAt),
+	}, err
+}
 
-	// Special handling for timeout
+// recordHistory appends an execution to the session history. Callers must
+// hold s.mutex.
+func (s *Session) recordHistory(ctx context.Context, code string, startedAt time.Time, stdout, stderr string, err error) {
+	status := StatusOK
 	if ctx.Err() == context.DeadlineExceeded {
-		return "", "", ctx.Err()
+		status = StatusTimeout
+	} else if err != nil {
+		status = StatusError
+	}
+
+	duration := time.Since(startedAt)
+	s.store.AppendHistory(s.key, HistoryEntry{
+		Code:       code,
+		StartedAt:  startedAt,
+		DurationMs: duration.Milliseconds(),
+		Stdout:     stdout,
+		Stderr:     stderr,
+		Status:     status,
+	})
+	s.manager.observeExecution(status, duration)
+}
+
+// replayCodes returns the code of every successful execution in order.
+// Callers must hold s.mutex.
+func (s *Session) replayCodes() ([]string, error) {
+	history, err := s.store.LoadHistory(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session history: %v", err)
+	}
+
+	var codes []string
+	for _, entry := range history {
+		if entry.Status == StatusOK {
+			codes = append(codes, entry.Code)
+		}
+	}
+	return codes, nil
+}
+
+// History returns every execution recorded in the session, oldest first
+func (s *Session) History() ([]HistoryEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.store.LoadHistory(s.key)
+}
+
+// loadState writes the stored state to the state file read by the
+// interpreter and returns it. Callers must hold s.mutex.
+func (s *Session) loadState() ([]byte, error) {
+	state, err := s.store.LoadState(s.key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session state: %v", err)
 	}
+	if err := os.WriteFile(s.statePath, state, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write session state: %v", err)
+	}
+	return state, nil
+}
 
-	return stdout.String(), stderr.String(), err
+// saveState copies the state file written by the interpreter into the store
+// and returns it. Callers must hold s.mutex.
+func (s *Session) saveState() ([]byte, error) {
+	state, err := os.ReadFile(s.statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session state: %v", err)
+	}
+	return state, s.store.SaveState(s.key, state)
+}
+
+// unrestorableNames returns the variables the state marks as unrestorable
+func unrestorableNames(state []byte) []string {
+	var names []string
+	for _, line := range bytes.Split(state, []byte("\n")) {
+		if name, ok := bytes.CutPrefix(line, []byte(unrestorableMarker)); ok {
+			names = append(names, string(name))
+		}
+	}
+	return names
+}
+
+// saveMetadata persists the session metadata. Callers must hold s.mutex or
+// have exclusive access to the session.
+func (s *Session) saveMetadata() error {
+	return s.store.SaveMetadata(Metadata{
+		ID:          s.ID,
+		Tenant:      s.tenant,
+		CreatedAt:   s.createdAt,
+		LastUsed:    s.LastUsed(),
+		IdleTimeout: s.lifetime.IdleTimeout,
+		MaxLifetime: s.lifetime.MaxLifetime,
+		Owner:       s.owner,
+	})
 }
 
 // CleanupSession terminates the session and removes its files
@@ -161,25 +892,189 @@ func (s *Session) Cleanup() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	s.cleanupLocked()
+}
+
+// cleanupLocked terminates the session. Callers must hold s.mutex.
+func (s *Session) cleanupLocked() {
 	if s.isRunning {
 		s.isRunning = false
-		// Remove the session directory
+		// Remove the stored records and the session directory
+		s.store.Delete(s.key)
 		os.RemoveAll(s.sessionDir)
+		atomic.StoreInt64(&s.diskUsage, 0)
+	}
+}
+
+// CreatedAt returns when the session was created
+func (s *Session) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// Owner returns who created the session, or an empty string if it is not
+// bound to anyone
+func (s *Session) Owner() string {
+	return s.owner
+}
+
+// LastUsed returns when code last ran in the session
+func (s *Session) LastUsed() time.Time {
+	return time.Unix(0, s.lastUsed.Load())
+}
+
+// setLastUsed records when code last ran in the session
+func (s *Session) setLastUsed(t time.Time) {
+	s.lastUsed.Store(t.UnixNano())
+}
+
+// ExpiresAt returns when the session expires if it is not used again.
+// defaultIdle applies when the session has no idle timeout of its own.
+func (s *Session) ExpiresAt(defaultIdle time.Duration) time.Time {
+	return expiresAt(s.createdAt, s.LastUsed(), s.lifetime, defaultIdle)
+}
+
+// expiresAt computes the earlier of the idle and absolute expiry times
+func expiresAt(createdAt, lastUsed time.Time, lifetime Lifetime, defaultIdle time.Duration) time.Time {
+	idle := lifetime.IdleTimeout
+	if idle == 0 {
+		idle = defaultIdle
+	}
+
+	expiry := lastUsed.Add(idle)
+	if lifetime.MaxLifetime > 0 {
+		if absolute := createdAt.Add(lifetime.MaxLifetime); absolute.Before(expiry) {
+			expiry = absolute
+		}
 	}
+	return expiry
 }
 
-// CleanupSessions removes old sessions
+// CleanupSessions removes expired sessions. maxAge is the idle timeout for
+// sessions that were created without one.
 func (m *Manager) CleanupSessions(maxAge time.Duration) {
+	now := time.Now()
+	var expired []*Session
+
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	for key, session := range m.sessions {
+		if now.After(session.ExpiresAt(maxAge)) {
+			expired = append(expired, session)
+			delete(m.sessions, key)
+		}
+	}
+	m.mutex.Unlock()
+
+	// Cleaning up waits for running executions, which may need m.mutex
+	for _, session := range expired {
+		session.Cleanup()
+		m.expired.Add(1)
+	}
+}
+
+// RemoveSessions terminates every session and removes its files
+func (m *Manager) RemoveSessions() {
+	m.mutex.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[string]*Session)
+	m.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.Cleanup()
+	}
+}
+
+// RestoreSessions re-registers sessions left in the store by a previous run
+// of the server. Sessions that have not expired are restored with their
+// original last-used time and lifetime; expired sessions and directories
+// without stored metadata that are older than maxAge are removed. maxAge is
+// the idle timeout for sessions that were created without one.
+// It returns the number of sessions restored.
+func (m *Manager) RestoreSessions(maxAge time.Duration) (int, error) {
+	metas, err := m.store.ListMetadata()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list stored sessions: %v", err)
+	}
 
 	now := time.Now()
-	for id, session := range m.sessions {
-		if now.Sub(session.lastUsed) > maxAge {
-			session.Cleanup()
-			delete(m.sessions, id)
+	restored := 0
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	known := make(map[string]bool)
+	for _, meta := range metas {
+		if ValidateID(meta.ID) != nil || ValidateTenant(meta.Tenant) != nil {
+			continue
+		}
+		key := meta.Key()
+		sessionDir := filepath.Join(m.baseDir, key)
+
+		lifetime := Lifetime{IdleTimeout: meta.IdleTimeout, MaxLifetime: meta.MaxLifetime}
+		if now.After(expiresAt(meta.CreatedAt, meta.LastUsed, lifetime, maxAge)) {
+			m.store.Delete(key)
+			os.RemoveAll(sessionDir)
+			m.expired.Add(1)
+			continue
+		}
+
+		known[key] = true
+		if _, exists := m.sessions[key]; exists {
+			continue
+		}
+
+		// The directories may be gone if state lives outside of them
+		session, err := m.newSession(meta.Tenant, meta.ID, meta.CreatedAt, meta.LastUsed, CreateOptions{Lifetime: lifetime, Owner: meta.Owner})
+		if err != nil {
+			continue
+		}
+
+		session.measureDisk()
+		m.sessions[key] = session
+		restored++
+	}
+
+	// Remove orphaned directories once they are old enough that they cannot
+	// belong to a session being created right now
+	if err := m.removeOrphans("", known, now, maxAge); err != nil {
+		return restored, err
+	}
+	tenants, err := os.ReadDir(filepath.Join(m.baseDir, tenantsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return restored, fmt.Errorf("failed to read tenant directory: %v", err)
+	}
+	for _, entry := range tenants {
+		if tenant, ok := tenantFromDir(entry.Name()); entry.IsDir() && ok {
+			if err := m.removeOrphans(tenant, known, now, maxAge); err != nil {
+				return restored, err
+			}
+		}
+	}
+
+	return restored, nil
+}
+
+// removeOrphans removes the session directories of tenant that belong to
+// no known or registered session and were last modified more than maxAge
+// ago. Callers must hold m.mutex.
+func (m *Manager) removeOrphans(tenant string, known map[string]bool, now time.Time, maxAge time.Duration) error {
+	entries, err := os.ReadDir(filepath.Join(m.baseDir, sessionKey(tenant, "")))
+	if err != nil {
+		return fmt.Errorf("failed to read session base directory: %v", err)
+	}
+	for _, entry := range entries {
+		key := sessionKey(tenant, entry.Name())
+		if !entry.IsDir() || known[key] || (tenant == "" && entry.Name() == tenantsDirName) {
+			continue
+		}
+		if _, exists := m.sessions[key]; exists {
+			continue
+		}
+		info, err := entry.Info()
+		if err == nil && now.Sub(info.ModTime()) > maxAge {
+			os.RemoveAll(filepath.Join(m.baseDir, key))
 		}
 	}
+	return nil
 }
 
 // GetSessionCount returns the current sessions (for testing purposes)
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -135,7 +137,7 @@ func TestCleanupSessions(t *testing.T) {
 	sessionDir := session.sessionDir
 
 	// Make the session appear old
-	session.lastUsed = time.Now().Add(-2 * time.Hour)
+	session.setLastUsed(time.Now().Add(-2 * time.Hour))
 
 	// Run cleanup with 1 hour max age
 	manager.CleanupSessions(1 * time.Hour)
@@ -151,6 +153,50 @@ func TestCleanupSessions(t *testing.T) {
 	}
 }
 
+func TestCleanupDuringExecution(t *testing.T) {
+	manager := NewManager()
+	manager.SetExecutionObserver(func(string, time.Duration) {})
+	session, err := manager.GetOrCreateSessionWithOptions("", CreateOptions{Lifetime: Lifetime{MaxLifetime: 500 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The session expires while it is still executing code
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := session.ExecuteCode(context.Background(), "import time; time.sleep(1.5)")
+		done <- err
+	}()
+	time.Sleep(750 * time.Millisecond)
+
+	cleaned := make(chan struct{})
+	go func() {
+		manager.CleanupSessions(time.Hour)
+		close(cleaned)
+	}()
+
+	for _, finished := range []<-chan struct{}{cleaned, waitFor(done)} {
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Cleanup and execution deadlocked")
+		}
+	}
+	if _, err := manager.GetSession(session.ID); err != ErrSessionNotFound {
+		t.Fatalf("Expected the expired session to be removed, got %v", err)
+	}
+}
+
+// waitFor returns a channel that is closed once a value is received from c
+func waitFor(c <-chan error) <-chan struct{} {
+	finished := make(chan struct{})
+	go func() {
+		<-c
+		close(finished)
+	}()
+	return finished
+}
+
 func TestSessionCleanup(t *testing.T) {
 	manager := NewManager()
 	session, err := manager.GetOrCreateSession("")
@@ -199,3 +245,318 @@ func TestConcurrentSessionUsage(t *testing.T) {
 		}
 	}
 }
+
+func TestRestoreSessions(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSessionWithOptions("", CreateOptions{Owner: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, _, err := session.ExecuteCode(context.Background(), "y = 'restored'"); err != nil {
+		t.Fatalf("Failed to set variable: %v", err)
+	}
+
+	// Simulate a restart with a fresh manager over the same base directory
+	restarted := NewManager()
+	if _, err := restarted.RestoreSessions(time.Hour); err != nil {
+		t.Fatalf("Failed to restore sessions: %v", err)
+	}
+
+	restoredSession, exists := restarted.sessions[session.ID]
+	if !exists {
+		t.Fatal("Expected session to be restored")
+	}
+
+	if !restoredSession.LastUsed().Equal(session.LastUsed()) {
+		t.Fatalf("Expected last used time %v, got %v", session.LastUsed(), restoredSession.LastUsed())
+	}
+	if restoredSession.Owner() != "alice" {
+		t.Fatalf("Expected owner alice, got %q", restoredSession.Owner())
+	}
+
+	stdout, _, err := restoredSession.ExecuteCode(context.Background(), "print(y)")
+	if err != nil {
+		t.Fatalf("Failed to execute code in restored session: %v", err)
+	}
+
+	if stdout != "restored\n" {
+		t.Fatalf("Expected stdout 'restored\\n', got '%s'", stdout)
+	}
+}
+
+func TestRestoreSessionsRemovesExpired(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Make the session appear old on disk
+	session.setLastUsed(time.Now().Add(-2 * time.Hour))
+	if err := session.saveMetadata(); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	// Leave an old directory without metadata behind
+	orphanDir := filepath.Join(manager.baseDir, "orphan-"+session.ID)
+	if err := os.MkdirAll(orphanDir, 0755); err != nil {
+		t.Fatalf("Failed to create orphan directory: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(orphanDir, old, old)
+
+	restarted := NewManager()
+	if _, err := restarted.RestoreSessions(time.Hour); err != nil {
+		t.Fatalf("Failed to restore sessions: %v", err)
+	}
+
+	if _, exists := restarted.sessions[session.ID]; exists {
+		t.Fatal("Expired session should not have been restored")
+	}
+
+	if _, err := os.Stat(session.sessionDir); !os.IsNotExist(err) {
+		t.Fatal("Expired session directory should have been removed")
+	}
+
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Fatal("Orphaned directory should have been removed")
+	}
+}
+
+func TestExecutionHistory(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	session.ExecuteCode(context.Background(), "print('first')")
+	session.ExecuteCode(context.Background(), "print(undefined_variable)")
+
+	history, err := session.History()
+	if err != nil {
+		t.Fatalf("Failed to load history: %v", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(history))
+	}
+
+	if history[0].Code != "print('first')" || history[0].Stdout != "first\n" || history[0].Status != StatusOK {
+		t.Fatalf("Unexpected first history entry: %+v", history[0])
+	}
+
+	if history[1].Status != StatusError || history[1].Stderr == "" {
+		t.Fatalf("Unexpected second history entry: %+v", history[1])
+	}
+}
+
+func TestStateKeepsDefinitionsAndObjects(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Functions and classes have no literal repr, so they are saved as
+	// their source and instances are pickled. Lambdas cannot be saved.
+	code := "def double(n):\n    print('side effect')\n    return n * 2\n\nclass Point:\n    def __init__(self, x):\n        self.x = x\n\npoint = Point(3)\ntriple = lambda n: n * 3"
+	result, err := session.Execute(context.Background(), code, ExecuteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to define function: %v", err)
+	}
+	if len(result.Unrestorable) != 1 || result.Unrestorable[0] != "triple" {
+		t.Fatalf("Expected only triple to be unrestorable, got %v", result.Unrestorable)
+	}
+
+	// Earlier executions are not re-run to restore them
+	stdout, stderr, _ := session.ExecuteCode(context.Background(), "print(double(point.x))\nprint(triple(1))")
+	if stdout != "side effect\n6\n" || !strings.Contains(stderr, "NameError") {
+		t.Fatalf("Expected double and point but not triple, got stdout '%s' and stderr '%s'", stdout, stderr)
+	}
+
+	// Definitions restored from the state are saved again
+	stdout, _, err = session.ExecuteCode(context.Background(), "print(Point(4).x, double(1))")
+	if err != nil || stdout != "side effect\n4 2\n" {
+		t.Fatalf("Expected definitions to survive another execution, got '%s': %v", stdout, err)
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	session.ExecuteCode(context.Background(), "items = []")
+	session.ExecuteCode(context.Background(), "items.append(1)\nprint('appended')")
+	session.ExecuteCode(context.Background(), "items.append(2)")
+	session.ExecuteCode(context.Background(), "def show():\n    print(items)")
+
+	replayed, _, err := manager.ReplaySession(context.Background(), session.ID, ExecuteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to replay session: %v", err)
+	}
+
+	if replayed.ID == session.ID {
+		t.Fatal("Expected replay to create a new session")
+	}
+
+	stdout, _, err := replayed.ExecuteCode(context.Background(), "show()")
+	if err != nil {
+		t.Fatalf("Failed to execute code in replayed session: %v", err)
+	}
+
+	if stdout != "[1, 2]\n" {
+		t.Fatalf("Expected stdout '[1, 2]\\n', got '%s'", stdout)
+	}
+
+	history, _ := replayed.History()
+	if len(history) != 5 {
+		t.Fatalf("Expected copied history plus one execution, got %d entries", len(history))
+	}
+
+	if _, _, err := manager.ReplaySession(context.Background(), "missing-session", ExecuteOptions{}); err != ErrSessionNotFound {
+		t.Fatalf("Expected ErrSessionNotFound, got: %v", err)
+	}
+}
+
+func TestCleanupSessionsHonorsLifetime(t *testing.T) {
+	manager := NewManager()
+
+	// A short idle timeout expires before the manager-wide limit
+	idle, err := manager.GetOrCreateSessionWithOptions("", CreateOptions{
+		Lifetime: Lifetime{IdleTimeout: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	idle.setLastUsed(time.Now().Add(-2 * time.Minute))
+
+	// A recently used session still expires once its max lifetime passes
+	old, err := manager.GetOrCreateSessionWithOptions("", CreateOptions{
+		Lifetime: Lifetime{MaxLifetime: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	old.createdAt = time.Now().Add(-2 * time.Hour)
+
+	// A long idle timeout outlives the manager-wide limit
+	patient, err := manager.GetOrCreateSessionWithOptions("", CreateOptions{
+		Lifetime: Lifetime{IdleTimeout: 3 * time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	patient.setLastUsed(time.Now().Add(-2 * time.Hour))
+
+	expectedExpiry := patient.LastUsed().Add(3 * time.Hour)
+	if !patient.ExpiresAt(time.Hour).Equal(expectedExpiry) {
+		t.Fatalf("Expected expiry %v, got %v", expectedExpiry, patient.ExpiresAt(time.Hour))
+	}
+
+	manager.CleanupSessions(1 * time.Hour)
+
+	if _, exists := manager.sessions[idle.ID]; exists {
+		t.Fatal("Session past its idle timeout should have been removed")
+	}
+
+	if _, exists := manager.sessions[old.ID]; exists {
+		t.Fatal("Session past its max lifetime should have been removed")
+	}
+
+	if _, exists := manager.sessions[patient.ID]; !exists {
+		t.Fatal("Session within its idle timeout should have been kept")
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"abc", "0b6c3c9e-6a4f-4a55-8d4e-7b1d0a9a7b0e", "user_session-1"} {
+		if err := ValidateID(id); err != nil {
+			t.Fatalf("Expected %q to be valid, got: %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"", "..", "../etc", "a/b", "a\\b", "-leading", "has space"} {
+		if err := ValidateID(id); err != ErrInvalidSessionID {
+			t.Fatalf("Expected %q to be invalid, got: %v", id, err)
+		}
+	}
+
+	manager := NewManager()
+	if _, err := manager.GetOrCreateSession("../escape"); err != ErrInvalidSessionID {
+		t.Fatalf("Expected ErrInvalidSessionID, got: %v", err)
+	}
+}
+
+func TestRemoveSessions(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		session, err := manager.GetOrCreateSession("")
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		dirs = append(dirs, session.sessionDir)
+	}
+
+	manager.RemoveSessions()
+
+	if count := len(manager.GetSessionCount()); count != 0 {
+		t.Fatalf("Expected no sessions, got %d", count)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("Expected session directory %s to be removed", dir)
+		}
+	}
+	if restored, _ := manager.RestoreSessions(time.Hour); restored != 0 {
+		t.Fatalf("Expected no sessions to restore, got %d", restored)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer manager.DeleteSession(session.ID)
+
+	code := "data = bytearray(512 * 1024 * 1024)\nprint(len(data))"
+	_, stderr, err := session.ExecuteCodeWithOptions(context.Background(), code, ExecuteOptions{MemoryLimit: 256 << 20})
+	if err == nil || !strings.Contains(stderr, "MemoryError") {
+		t.Fatalf("Expected MemoryError, got err %v and stderr %q", err, stderr)
+	}
+
+	// The limit applies to a single execution
+	stdout, _, err := session.ExecuteCode(context.Background(), "print(sum(range(10)))")
+	if err != nil || stdout != "45\n" {
+		t.Fatalf("Expected unlimited execution to succeed, got %q, %v", stdout, err)
+	}
+}
+
+func TestExecuteReportsUsage(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer manager.DeleteSession(session.ID)
+
+	code := "import time\nstart = time.process_time()\nwhile time.process_time() - start < 0.2:\n    pass\nprint('done')"
+	result, err := session.Execute(context.Background(), code, ExecuteOptions{})
+	if err != nil || result.Stdout != "done\n" {
+		t.Fatalf("Expected execution to succeed, got %q, %v", result.Stdout, err)
+	}
+	if result.Usage.CPUTime < 200*time.Millisecond || result.Usage.WallTime < result.Usage.CPUTime/2 {
+		t.Fatalf("Expected at least 200ms of CPU time, got %+v", result.Usage)
+	}
+}
@@ -0,0 +1,296 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bootstrapScript runs in every pooled interpreter. It runs the preload
+// script with its output discarded, signals readiness on file descriptor 3,
+// then waits for the path of an execution script on stdin and runs it in a
+// fresh __main__ module, so pickle finds user classes where it would in a
+// plain interpreter.
+const bootstrapScript = `
+import contextlib, io, os, sys, types
+
+if sys.argv[1]:
+    try:
+        with contextlib.redirect_stdout(io.StringIO()), contextlib.redirect_stderr(io.StringIO()):
+            exec(compile(open(sys.argv[1]).read(), sys.argv[1], "exec"), {"__name__": "__preload__"})
+    except BaseException:
+        pass  # A broken preload script only costs the speedup
+
+os.write(3, b"\n")
+os.close(3)
+
+path = sys.stdin.readline().strip()
+if not path:
+    sys.exit(0)
+sys.argv = [path]
+main = types.ModuleType("__main__")
+main.__builtins__ = __builtins__
+sys.modules["__main__"] = main
+exec(compile(open(path).read(), path, "exec"), main.__dict__)
+`
+
+// errProcessUnavailable is returned when a pooled interpreter died before
+// it could be used
+var errProcessUnavailable = errors.New("pooled interpreter is no longer available")
+
+// PoolOptions configures the pool of pre-started interpreters
+type PoolOptions struct {
+	// Size is the number of idle interpreters kept ready. Zero disables the
+	// pool.
+	Size int
+	// Preload is the path of a Python script every pooled interpreter runs
+	// before it is handed out, typically to import heavy libraries
+	Preload string
+}
+
+// PoolStats describes the state of the interpreter pool
+type PoolStats struct {
+	Size   int   `json:"size"`
+	Idle   int   `json:"idle"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// pool keeps interpreters started ahead of time so executions with the
+// system interpreter do not pay for startup and preloaded imports
+type pool struct {
+	python string
+	opts   PoolOptions
+	idle   chan *warmProcess
+	refill chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// warmProcess is a started interpreter waiting for a script to run
+type warmProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	ready  chan error
+}
+
+// newPool starts a pool and fills it in the background
+func newPool(python string, opts PoolOptions) *pool {
+	p := &pool{
+		python: python,
+		opts:   opts,
+		idle:   make(chan *warmProcess, opts.Size),
+		refill: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.fill()
+	p.refill <- struct{}{}
+	return p
+}
+
+// fill starts interpreters whenever the pool is below its size
+func (p *pool) fill() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-p.refill:
+		}
+
+		for len(p.idle) < cap(p.idle) {
+			proc, err := startWarmProcess(p.python, p.opts.Preload)
+			if err == nil {
+				// Only hand out interpreters that finished preloading
+				select {
+				case err = <-proc.ready:
+				case <-p.stop:
+					proc.kill()
+					return
+				}
+				if err != nil {
+					proc.kill()
+				}
+			}
+			if err != nil {
+				// Try again on the next request rather than spinning
+				break
+			}
+			select {
+			case p.idle <- proc:
+			case <-p.stop:
+				proc.kill()
+				return
+			}
+		}
+	}
+}
+
+// get returns an idle interpreter, or nil if none is ready, and triggers a
+// refill in the background
+func (p *pool) get() *warmProcess {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+
+	select {
+	case proc := <-p.idle:
+		return proc
+	default:
+		return nil
+	}
+}
+
+// stats reports the pool size and hit/miss counts
+func (p *pool) stats() PoolStats {
+	return PoolStats{
+		Size:   p.opts.Size,
+		Idle:   len(p.idle),
+		Hits:   p.hits.Load(),
+		Misses: p.misses.Load(),
+	}
+}
+
+// close stops refilling and terminates all idle interpreters
+func (p *pool) close() {
+	close(p.stop)
+	p.wg.Wait()
+	for {
+		select {
+		case proc := <-p.idle:
+			proc.kill()
+		default:
+			return
+		}
+	}
+}
+
+// startWarmProcess starts an interpreter running the bootstrap script. Its
+// ready channel receives nil once the preload script has run.
+func startWarmProcess(python, preload string) (*warmProcess, error) {
+	proc := &warmProcess{
+		cmd:   exec.Command(python, "-c", bootstrapScript, preload),
+		ready: make(chan error, 1),
+	}
+	proc.cmd.Stdout = &proc.stdout
+	proc.cmd.Stderr = &proc.stderr
+
+	stdin, err := proc.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.stdin = stdin
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	proc.cmd.ExtraFiles = []*os.File{readyWriter}
+
+	err = proc.cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		readyReader.Close()
+		stdin.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer readyReader.Close()
+		_, err := readyReader.Read(make([]byte, 1))
+		proc.ready <- err
+	}()
+	return proc, nil
+}
+
+// run hands the script to the interpreter and waits for it to finish,
+// killing it when ctx is done
+func (proc *warmProcess) run(ctx context.Context, scriptPath string) (Result, error) {
+	startedAt := time.Now()
+	if _, err := io.WriteString(proc.stdin, scriptPath+"\n"); err != nil {
+		proc.kill()
+		return Result{}, errProcessUnavailable
+	}
+	proc.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			proc.cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	err := proc.cmd.Wait()
+	close(done)
+	return Result{
+		Stdout: proc.stdout.String(),
+		Stderr: proc.stderr.String(),
+		Usage:  processUsage(proc.cmd.ProcessState, startedAt),
+	}, err
+}
+
+// kill terminates an interpreter that will not be used
+func (proc *warmProcess) kill() {
+	proc.stdin.Close()
+	proc.cmd.Process.Kill()
+	proc.cmd.Wait()
+}
+
+// ConfigurePool replaces the interpreter pool. A size of zero disables it.
+func (m *Manager) ConfigurePool(opts PoolOptions) {
+	var next *pool
+	if opts.Size > 0 {
+		next = newPool(m.python, opts)
+	}
+
+	if previous := m.pool.Swap(next); previous != nil {
+		previous.close()
+	}
+}
+
+// PoolStats reports the state of the interpreter pool
+func (m *Manager) PoolStats() PoolStats {
+	p := m.pool.Load()
+	if p == nil {
+		return PoolStats{}
+	}
+	return p.stats()
+}
+
+// runPooled runs the script in a pooled interpreter and reports whether one
+// was available. Callers fall back to starting a new interpreter otherwise.
+func (m *Manager) runPooled(ctx context.Context, scriptPath string) (result Result, ran bool, err error) {
+	p := m.pool.Load()
+	if p == nil {
+		return Result{}, false, nil
+	}
+
+	proc := p.get()
+	if proc == nil {
+		p.misses.Add(1)
+		return Result{}, false, nil
+	}
+
+	result, err = proc.run(ctx, scriptPath)
+	if err == errProcessUnavailable {
+		p.misses.Add(1)
+		return Result{}, false, nil
+	}
+	p.hits.Add(1)
+	return result, true, err
+}
@@ -0,0 +1,188 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForIdle waits until the pool has n idle interpreters
+func waitForIdle(t *testing.T, manager *Manager, n int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for manager.PoolStats().Idle < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d idle interpreters, got %d", n, manager.PoolStats().Idle)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWarmPool(t *testing.T) {
+	preload := filepath.Join(t.TempDir(), "preload.py")
+	if err := os.WriteFile(preload, []byte("import json\nimport builtins\nbuiltins.PRELOADED = True\nprint('hidden')\n"), 0644); err != nil {
+		t.Fatalf("Failed to write preload script: %v", err)
+	}
+
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir: t.TempDir(),
+		Pool:    PoolOptions{Size: 2, Preload: preload},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	waitForIdle(t, manager, 2)
+
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The preload script has run, its output is discarded, and state still
+	// carries over between pooled executions
+	stdout, _, err := session.ExecuteCode(context.Background(), "x = 41\nprint(PRELOADED, __name__)")
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v", err)
+	}
+	if strings.TrimSpace(stdout) != "True __main__" {
+		t.Fatalf("Expected 'True __main__', got: %q", stdout)
+	}
+
+	stdout, _, err = session.ExecuteCode(context.Background(), "import os\nprint(x + 1, os.path.basename(os.getcwd()))")
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v", err)
+	}
+	if strings.TrimSpace(stdout) != "42 "+workspaceDirName {
+		t.Fatalf("Expected '42 %s', got: %q", workspaceDirName, stdout)
+	}
+
+	stats := manager.PoolStats()
+	if stats.Size != 2 || stats.Hits != 2 || stats.Misses != 0 {
+		t.Fatalf("Expected size 2 with 2 hits and no misses, got: %+v", stats)
+	}
+
+	// The pool is replenished in the background
+	waitForIdle(t, manager, 2)
+}
+
+func TestWarmPoolTimeout(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir: t.TempDir(),
+		Pool:    PoolOptions{Size: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	waitForIdle(t, manager, 1)
+
+	session, _ := manager.GetOrCreateSession("")
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := session.ExecuteCode(ctx, "while True: pass"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the pooled interpreter to be killed, took %v", elapsed)
+	}
+	if manager.PoolStats().Hits != 1 {
+		t.Fatalf("Expected 1 hit, got: %+v", manager.PoolStats())
+	}
+}
+
+func TestWarmPoolMiss(t *testing.T) {
+	// A slow preload script keeps the pool empty
+	preload := filepath.Join(t.TempDir(), "preload.py")
+	if err := os.WriteFile(preload, []byte("import time\ntime.sleep(30)\n"), 0644); err != nil {
+		t.Fatalf("Failed to write preload script: %v", err)
+	}
+
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir: t.TempDir(),
+		Pool:    PoolOptions{Size: 1, Preload: preload},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	session, _ := manager.GetOrCreateSession("")
+	stdout, _, err := session.ExecuteCode(context.Background(), "print('cold')")
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v", err)
+	}
+	if strings.TrimSpace(stdout) != "cold" {
+		t.Fatalf("Expected 'cold', got: %q", stdout)
+	}
+
+	stats := manager.PoolStats()
+	if stats.Hits != 0 || stats.Misses != 1 {
+		t.Fatalf("Expected no hits and 1 miss, got: %+v", stats)
+	}
+}
+
+func TestWarmPoolPicklesClasses(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir: t.TempDir(),
+		Pool:    PoolOptions{Size: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	waitForIdle(t, manager, 1)
+
+	// Instances of classes defined in a pooled run are saved and restored
+	// like in any other run
+	session, _ := manager.GetOrCreateSession("")
+	code := "class Point:\n    def __init__(self, x):\n        self.x = x\n\np = Point(3)"
+	if _, stderr, err := session.ExecuteCode(context.Background(), code); err != nil {
+		t.Fatalf("Failed to execute code: %v, stderr: %s", err, stderr)
+	}
+
+	waitForIdle(t, manager, 1)
+	stdout, stderr, err := session.ExecuteCode(context.Background(), "print(type(p).__name__, p.x)")
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v, stderr: %s", err, stderr)
+	}
+	if strings.TrimSpace(stdout) != "Point 3" {
+		t.Fatalf("Expected 'Point 3', got: %q", stdout)
+	}
+	if manager.PoolStats().Hits != 2 {
+		t.Fatalf("Expected 2 hits, got: %+v", manager.PoolStats())
+	}
+}
+
+func TestWarmPoolExitCode(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir: t.TempDir(),
+		Pool:    PoolOptions{Size: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	waitForIdle(t, manager, 1)
+
+	// The exit status of a pooled interpreter reaches the caller
+	session, _ := manager.GetOrCreateSession("")
+	_, _, err = session.ExecuteCode(context.Background(), "import sys\nsys.exit(3)")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected exit code 3, got: %v", err)
+	}
+	if manager.PoolStats().Hits != 1 {
+		t.Fatalf("Expected 1 hit, got: %+v", manager.PoolStats())
+	}
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is returned when a session or the server as a whole uses
+// more disk space than allowed
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// QuotaPolicy decides what happens when the global disk quota is exceeded
+type QuotaPolicy string
+
+const (
+	// QuotaReject refuses further executions and uploads until usage drops
+	QuotaReject QuotaPolicy = "reject"
+	// QuotaEvict removes the least recently used idle sessions to make room
+	QuotaEvict QuotaPolicy = "evict"
+)
+
+// DiskQuota limits the disk space used by sessions. Zero limits are
+// unlimited.
+type DiskQuota struct {
+	// Session is the maximum number of bytes a single session may use
+	Session int64
+	// Global is the maximum number of bytes all sessions together may use
+	Global int64
+	// Policy applies when the global quota is exceeded
+	Policy QuotaPolicy
+}
+
+// SetDiskQuota changes the disk quota for all sessions
+func (m *Manager) SetDiskQuota(quota DiskQuota) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.quota = quota
+}
+
+// diskQuota returns the current disk quota
+func (m *Manager) diskQuota() DiskQuota {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.quota
+}
+
+// DiskUsage returns the bytes used by all sessions as of their last
+// measurement
+func (m *Manager) DiskUsage() int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var total int64
+	for _, session := range m.sessions {
+		total += session.DiskUsage()
+	}
+	return total
+}
+
+// DiskUsage returns the bytes used by the session as of its last execution
+// or upload
+func (s *Session) DiskUsage() int64 {
+	return atomic.LoadInt64(&s.diskUsage)
+}
+
+// measureDisk recalculates the bytes used by the session directory
+func (s *Session) measureDisk() int64 {
+	var total int64
+	filepath.WalkDir(s.sessionDir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	atomic.StoreInt64(&s.diskUsage, total)
+	return total
+}
+
+// checkQuota decides whether the session may use more disk space. When the
+// global quota is exceeded under the evict policy, idle sessions are removed
+// oldest first until usage fits again. It must not be called while holding
+// any session mutex.
+func (m *Manager) checkQuota(s *Session) error {
+	quota := m.diskQuota()
+
+	if quota.Session > 0 && s.DiskUsage() > quota.Session {
+		return ErrQuotaExceeded
+	}
+
+	if quota.Global > 0 && m.DiskUsage() > quota.Global {
+		if quota.Policy != QuotaEvict || !m.evictUntil(quota.Global, s) {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// evictUntil removes the least recently used sessions other than keep until
+// the total usage is within limit and reports whether that succeeded.
+// Sessions that are busy executing code are skipped.
+func (m *Manager) evictUntil(limit int64, keep *Session) bool {
+	m.mutex.RLock()
+	candidates := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if session != keep {
+			candidates = append(candidates, session)
+		}
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastUsed().Before(candidates[j].LastUsed())
+	})
+
+	for _, session := range candidates {
+		if m.DiskUsage() <= limit {
+			return true
+		}
+		if !session.mutex.TryLock() {
+			continue
+		}
+		session.cleanupLocked()
+		session.mutex.Unlock()
+
+		m.mutex.Lock()
+		delete(m.sessions, session.key)
+		m.mutex.Unlock()
+		m.evicted.Add(1)
+	}
+	return m.DiskUsage() <= limit
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionDiskQuota(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir:   t.TempDir(),
+		DiskQuota: DiskQuota{Session: 64 * 1024},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, _, err := session.ExecuteCode(context.Background(), "open('big.bin', 'wb').write(b'x' * 100000)"); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if session.DiskUsage() < 100000 {
+		t.Fatalf("Expected disk usage of at least 100000 bytes, got %d", session.DiskUsage())
+	}
+
+	if _, _, err := session.ExecuteCode(context.Background(), "print('over quota')"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got: %v", err)
+	}
+
+	// Freeing space lets the session run again
+	if err := session.RemoveFile("big.bin"); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	if _, _, err := session.ExecuteCode(context.Background(), "print('back under quota')"); err != nil {
+		t.Fatalf("Expected execution to succeed after freeing space, got: %v", err)
+	}
+}
+
+func TestGlobalDiskQuotaPolicies(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{
+		BaseDir:   t.TempDir(),
+		DiskQuota: DiskQuota{Global: 64 * 1024, Policy: QuotaReject},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	old, _ := manager.GetOrCreateSession("")
+	if _, _, err := old.ExecuteCode(context.Background(), "open('big.bin', 'wb').write(b'x' * 100000)"); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	old.setLastUsed(time.Now().Add(-time.Minute))
+
+	current, _ := manager.GetOrCreateSession("")
+	if _, _, err := current.ExecuteCode(context.Background(), "print('hi')"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded under the reject policy, got: %v", err)
+	}
+
+	manager.SetDiskQuota(DiskQuota{Global: 64 * 1024, Policy: QuotaEvict})
+	if _, _, err := current.ExecuteCode(context.Background(), "print('hi')"); err != nil {
+		t.Fatalf("Expected execution to succeed under the evict policy, got: %v", err)
+	}
+
+	if _, err := manager.GetSession(old.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected the oldest session to be evicted, got: %v", err)
+	}
+	if manager.SessionsEvicted() != 1 {
+		t.Fatalf("Expected 1 evicted session, got %d", manager.SessionsEvicted())
+	}
+}
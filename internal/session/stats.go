@@ -0,0 +1,40 @@
+package session
+
+import "time"
+
+// ExecutionObserver is called after every execution with its history status
+// and how long the interpreter ran
+type ExecutionObserver func(status string, duration time.Duration)
+
+// SetExecutionObserver registers the function called after every execution
+func (m *Manager) SetExecutionObserver(observer ExecutionObserver) {
+	if observer == nil {
+		m.observer.Store(nil)
+		return
+	}
+	m.observer.Store(&observer)
+}
+
+// observeExecution reports a finished execution to the observer, if any
+func (m *Manager) observeExecution(status string, duration time.Duration) {
+	if observer := m.observer.Load(); observer != nil {
+		(*observer)(status, duration)
+	}
+}
+
+// SessionsCreated returns how many sessions the manager has created
+func (m *Manager) SessionsCreated() int64 {
+	return m.created.Load()
+}
+
+// SessionsExpired returns how many sessions the manager has removed because
+// they expired
+func (m *Manager) SessionsExpired() int64 {
+	return m.expired.Load()
+}
+
+// SessionsEvicted returns how many sessions the manager has removed to get
+// back under the global disk quota
+func (m *Manager) SessionsEvicted() int64 {
+	return m.evicted.Load()
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManagerStats(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	var statuses []string
+	manager.SetExecutionObserver(func(status string, duration time.Duration) {
+		if duration <= 0 {
+			t.Errorf("Expected a positive duration, got %v", duration)
+		}
+		statuses = append(statuses, status)
+	})
+
+	session, _ := manager.GetOrCreateSession("")
+	manager.GetOrCreateSession("")
+	session.ExecuteCode(context.Background(), "print('ok')")
+	session.ExecuteCode(context.Background(), "raise ValueError()")
+
+	if len(statuses) != 2 || statuses[0] != StatusOK || statuses[1] != StatusError {
+		t.Fatalf("Expected ok and error executions, got %v", statuses)
+	}
+
+	// A replay counts as one more execution
+	if _, _, err := manager.ReplaySession(context.Background(), session.ID, ExecuteOptions{}); err != nil {
+		t.Fatalf("Failed to replay session: %v", err)
+	}
+	if len(statuses) != 3 || statuses[2] != StatusOK {
+		t.Fatalf("Expected the replay to be observed, got %v", statuses)
+	}
+
+	session.setLastUsed(time.Now().Add(-time.Hour))
+	manager.CleanupSessions(time.Minute)
+
+	if manager.SessionsCreated() != 3 || manager.SessionsExpired() != 1 {
+		t.Fatalf("Expected 3 created and 1 expired sessions, got %d and %d", manager.SessionsCreated(), manager.SessionsExpired())
+	}
+}
@@ -0,0 +1,235 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when a session has no stored record
+var ErrNotFound = errors.New("session not found in store")
+
+// Metadata describes a session independently of where it is stored
+type Metadata struct {
+	ID          string        `json:"id"`
+	Tenant      string        `json:"tenant,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	LastUsed    time.Time     `json:"last_used"`
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	MaxLifetime time.Duration `json:"max_lifetime,omitempty"`
+	Owner       string        `json:"owner,omitempty"`
+}
+
+// Execution statuses recorded in the history
+const (
+	StatusOK      = "ok"
+	StatusError   = "error"
+	StatusTimeout = "timeout"
+)
+
+// HistoryEntry records a single execution in a session
+type HistoryEntry struct {
+	Code       string    `json:"code"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Stdout     string    `json:"stdout,omitempty"`
+	Stderr     string    `json:"stderr,omitempty"`
+	Status     string    `json:"status"`
+}
+
+// Store persists session metadata and state blobs so that sessions can outlive
+// the process that created them. Sessions are identified by their key, which
+// is the session ID for sessions of the default tenant.
+type Store interface {
+	// SaveMetadata creates or replaces the metadata of a session
+	SaveMetadata(meta Metadata) error
+	// LoadMetadata returns the metadata of a session or ErrNotFound
+	LoadMetadata(id string) (Metadata, error)
+	// ListMetadata returns the metadata of every stored session
+	ListMetadata() ([]Metadata, error)
+	// SaveState replaces the serialized Python state of a session
+	SaveState(id string, state []byte) error
+	// LoadState returns the serialized Python state of a session or ErrNotFound
+	LoadState(id string) ([]byte, error)
+	// AppendHistory adds an execution to the end of the session history
+	AppendHistory(id string, entry HistoryEntry) error
+	// LoadHistory returns the session history in execution order
+	LoadHistory(id string) ([]HistoryEntry, error)
+	// Delete removes everything stored for a session
+	Delete(id string) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// FileStore keeps metadata, state and history as files in the hidden harness
+// directory of each session. It is the default store.
+type FileStore struct {
+	baseDir string
+}
+
+// NewFileStore creates a store rooted at the session base directory
+func NewFileStore(baseDir string) *FileStore {
+	return &FileStore{baseDir: baseDir}
+}
+
+// harnessDir is the hidden directory of a session the files are kept in
+func (f *FileStore) harnessDir(id string) string {
+	return filepath.Join(f.baseDir, id, harnessDirName)
+}
+
+// SaveMetadata writes the metadata file of a session
+func (f *FileStore) SaveMetadata(meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode session metadata: %v", err)
+	}
+	if err := os.MkdirAll(f.harnessDir(meta.Key()), 0755); err != nil {
+		return fmt.Errorf("failed to create harness directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(f.harnessDir(meta.Key()), metadataFileName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write session metadata: %v", err)
+	}
+	return nil
+}
+
+// LoadMetadata reads the metadata file of a session
+func (f *FileStore) LoadMetadata(id string) (Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(f.harnessDir(id), metadataFileName))
+	if os.IsNotExist(err) {
+		return Metadata{}, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Metadata{}, fmt.Errorf("failed to decode session metadata: %v", err)
+	}
+	return meta, nil
+}
+
+// ListMetadata returns the metadata of every session directory, including
+// those of named tenants, that has a valid metadata file; directories
+// without one are skipped
+func (f *FileStore) ListMetadata() ([]Metadata, error) {
+	metas, err := f.listMetadata("")
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := os.ReadDir(filepath.Join(f.baseDir, tenantsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read tenant directory: %v", err)
+	}
+	for _, entry := range tenants {
+		tenant, ok := tenantFromDir(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		tenantMetas, err := f.listMetadata(tenant)
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, tenantMetas...)
+	}
+	return metas, nil
+}
+
+// listMetadata returns the metadata of the session directories of tenant
+func (f *FileStore) listMetadata(tenant string) ([]Metadata, error) {
+	entries, err := os.ReadDir(filepath.Join(f.baseDir, sessionKey(tenant, "")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session base directory: %v", err)
+	}
+
+	var metas []Metadata
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		meta, err := f.LoadMetadata(sessionKey(tenant, entry.Name()))
+		if err != nil || meta.ID != entry.Name() || meta.Tenant != tenant {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// SaveState writes the state file of a session
+func (f *FileStore) SaveState(id string, state []byte) error {
+	if err := os.MkdirAll(f.harnessDir(id), 0755); err != nil {
+		return fmt.Errorf("failed to create harness directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(f.harnessDir(id), stateFileName), state, 0644); err != nil {
+		return fmt.Errorf("failed to write session state: %v", err)
+	}
+	return nil
+}
+
+// LoadState reads the state file of a session
+func (f *FileStore) LoadState(id string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(f.harnessDir(id), stateFileName))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// AppendHistory appends an execution as a JSON line to the history file
+func (f *FileStore) AppendHistory(id string, entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(f.harnessDir(id), historyFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open session history: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write session history: %v", err)
+	}
+	return nil
+}
+
+// LoadHistory reads the history file of a session
+func (f *FileStore) LoadHistory(id string) ([]HistoryEntry, error) {
+	file, err := os.Open(filepath.Join(f.harnessDir(id), historyFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session history: %v", err)
+	}
+	defer file.Close()
+
+	var history []HistoryEntry
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry HistoryEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to decode session history: %v", err)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// Delete removes the metadata, state and history files of a session
+func (f *FileStore) Delete(id string) error {
+	for _, name := range []string{metadataFileName, stateFileName, historyFileName} {
+		if err := os.Remove(filepath.Join(f.harnessDir(id), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op for the file store
+func (f *FileStore) Close() error {
+	return nil
+}
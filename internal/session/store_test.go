@@ -0,0 +1,133 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore exercises the behavior every Store implementation must provide
+func testStore(t *testing.T, store Store) {
+	meta := Metadata{
+		ID:        "store-test",
+		CreatedAt: time.Now().Add(-time.Minute).UTC(),
+		LastUsed:  time.Now().UTC(),
+	}
+
+	if _, err := store.LoadMetadata(meta.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for missing metadata, got: %v", err)
+	}
+
+	if err := store.SaveMetadata(meta); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+
+	loaded, err := store.LoadMetadata(meta.ID)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if loaded.ID != meta.ID || !loaded.LastUsed.Equal(meta.LastUsed) {
+		t.Fatalf("Expected metadata %+v, got %+v", meta, loaded)
+	}
+
+	metas, err := store.ListMetadata()
+	if err != nil {
+		t.Fatalf("Failed to list metadata: %v", err)
+	}
+	if len(metas) != 1 || metas[0].ID != meta.ID {
+		t.Fatalf("Expected one stored session, got %+v", metas)
+	}
+
+	state := []byte("x = 42\n")
+	if err := store.SaveState(meta.ID, state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	loadedState, err := store.LoadState(meta.ID)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if !bytes.Equal(loadedState, state) {
+		t.Fatalf("Expected state %q, got %q", state, loadedState)
+	}
+
+	if err := store.Delete(meta.ID); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+
+	if _, err := store.LoadState(meta.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after delete, got: %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, NewFileStore(t.TempDir()))
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt store: %v", err)
+	}
+	defer store.Close()
+
+	testStore(t, store)
+}
+
+func TestManagerWithBoltStore(t *testing.T) {
+	baseDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "sessions.db")
+
+	store, err := NewBoltStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open bolt store: %v", err)
+	}
+
+	manager, err := NewManagerWithOptions(Options{BaseDir: baseDir, Store: store})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, _, err := session.ExecuteCode(context.Background(), "z = [1, 2, 3]"); err != nil {
+		t.Fatalf("Failed to set variable: %v", err)
+	}
+	manager.Close()
+
+	// Reopen the database with a manager whose working directories are gone
+	store, err = NewBoltStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt store: %v", err)
+	}
+
+	restarted, err := NewManagerWithOptions(Options{BaseDir: t.TempDir(), Store: store})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer restarted.Close()
+
+	if n, err := restarted.RestoreSessions(time.Hour); err != nil || n != 1 {
+		t.Fatalf("Expected one restored session, got %d (err: %v)", n, err)
+	}
+
+	restoredSession, err := restarted.GetOrCreateSession(session.ID)
+	if err != nil {
+		t.Fatalf("Failed to get restored session: %v", err)
+	}
+
+	stdout, _, err := restoredSession.ExecuteCode(context.Background(), "print(sum(z))")
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v", err)
+	}
+
+	if stdout != "6\n" {
+		t.Fatalf("Expected stdout '6\\n', got '%s'", stdout)
+	}
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tenantsDirName holds one directory of sessions per named tenant in the
+// base directory. Session IDs start with a letter or digit, so it cannot
+// clash with the directory of a session of the default tenant. The
+// directories only separate tenants at the API: every interpreter runs as
+// the same OS user and can reach the sessions of other tenants.
+const tenantsDirName = "_tenants"
+
+// maxTenantDirLen keeps the directory names of tenants within the file
+// name limit of common file systems
+const maxTenantDirLen = 255
+
+var (
+	// ErrInvalidTenant is returned for tenant names too long to be stored
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrTenantSessionLimit is returned when a tenant already has as many
+	// sessions as it may
+	ErrTenantSessionLimit = errors.New("tenant session limit reached")
+)
+
+// ValidateTenant reports whether tenant can be used as a tenant name. The
+// empty name is the default tenant. Any other name can be used, such as a
+// domain name, as long as its directory name is not too long.
+func ValidateTenant(tenant string) error {
+	if len(tenantDir(tenant)) > maxTenantDirLen {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// tenantDir returns the name of the directory of tenant within
+// tenantsDirName. Letters, digits, '_' and '-' are kept and every other
+// byte is written as %XX, so every name maps to a distinct single path
+// element, and names that are valid session IDs keep their own name.
+func tenantDir(tenant string) string {
+	var dir strings.Builder
+	for i := 0; i < len(tenant); i++ {
+		c := tenant[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-' {
+			dir.WriteByte(c)
+		} else {
+			fmt.Fprintf(&dir, "%%%02X", c)
+		}
+	}
+	return dir.String()
+}
+
+// tenantFromDir returns the tenant whose directory is name, reporting false
+// for names tenantDir does not produce
+func tenantFromDir(name string) (string, bool) {
+	tenant, err := url.PathUnescape(name)
+	if err != nil || tenant == "" || tenantDir(tenant) != name || ValidateTenant(tenant) != nil {
+		return "", false
+	}
+	return tenant, true
+}
+
+// sessionKey is what a session is stored and tracked under: its ID for the
+// default tenant, and its directory relative to the base directory for
+// named tenants
+func sessionKey(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return filepath.Join(tenantsDirName, tenantDir(tenant), id)
+}
+
+// Key returns what the session is stored under in the session store
+func (meta Metadata) Key() string {
+	return sessionKey(meta.Tenant, meta.ID)
+}
+
+// Tenant returns the tenant the session belongs to, which is empty for the
+// default tenant
+func (s *Session) Tenant() string {
+	return s.tenant
+}
+
+// Key identifies the session across tenants. It is the ID for sessions of
+// the default tenant.
+func (s *Session) Key() string {
+	return s.key
+}
+
+// HasSession reports whether the session with the given key exists
+func (m *Manager) HasSession(key string) bool {
+	m.mutex.RLock()
+	session, exists := m.sessions[key]
+	m.mutex.RUnlock()
+	return exists && session.isRunning
+}
+
+// SetTenantSessionLimit changes the maximum number of sessions of each
+// tenant; zero removes the limit. Existing sessions are kept.
+func (m *Manager) SetTenantSessionLimit(limit int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tenantMax = limit
+}
+
+// tenantFull reports whether tenant cannot have another session besides
+// the one with the given key. Callers must hold m.mutex.
+func (m *Manager) tenantFull(tenant, key string) bool {
+	if m.tenantMax <= 0 {
+		return false
+	}
+	count := 0
+	for existing, session := range m.sessions {
+		if session.tenant == tenant && existing != key {
+			count++
+		}
+	}
+	return count >= m.tenantMax
+}
+
+// Tenant is the view of a Manager limited to the sessions of one tenant.
+// Sessions of other tenants cannot be looked up, listed or removed through
+// it, even when they have the same ID.
+type Tenant struct {
+	name    string
+	manager *Manager
+}
+
+// Tenant returns the view of the sessions of the named tenant. The empty
+// name is the default tenant the Manager methods work on. Invalid names
+// are reported with ErrInvalidTenant by the methods of the view.
+func (m *Manager) Tenant(name string) *Tenant {
+	return &Tenant{name: name, manager: m}
+}
+
+// Name returns the name of the tenant
+func (t *Tenant) Name() string {
+	return t.name
+}
+
+// GetSession retrieves an existing session of the tenant
+func (t *Tenant) GetSession(id string) (*Session, error) {
+	return t.manager.getSession(t.name, id)
+}
+
+// GetOrCreateSessionWithOptions retrieves an existing session of the tenant
+// or creates a new one configured by opts
+func (t *Tenant) GetOrCreateSessionWithOptions(id string, opts CreateOptions) (*Session, error) {
+	return t.manager.getOrCreateSession(t.name, id, opts)
+}
+
+// CreateSession creates a new session of the tenant with a server-generated
+// ID
+func (t *Tenant) CreateSession(opts CreateOptions) (*Session, error) {
+	return t.manager.createNewSession(t.name, "", opts)
+}
+
+// ReplaySession rebuilds a session of the tenant into a new one
+func (t *Tenant) ReplaySession(ctx context.Context, id string, opts ExecuteOptions) (*Session, Usage, error) {
+	return t.manager.replaySession(ctx, t.name, id, opts)
+}
+
+// DeleteSession removes a session of the tenant and its files
+func (t *Tenant) DeleteSession(id string) error {
+	return t.manager.deleteSession(t.name, id)
+}
+
+// ListSessions returns the sessions of the tenant, oldest first
+func (t *Tenant) ListSessions() []*Session {
+	t.manager.mutex.RLock()
+	var sessions []*Session
+	for _, session := range t.manager.sessions {
+		if session.tenant == t.name && session.isRunning {
+			sessions = append(sessions, session)
+		}
+	}
+	t.manager.mutex.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].createdAt.Equal(sessions[j].createdAt) {
+			return sessions[i].createdAt.Before(sessions[j].createdAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
@@ -0,0 +1,196 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	acme, globex := manager.Tenant("acme"), manager.Tenant("globex")
+
+	// The same ID names a different session in every tenant
+	for _, tenant := range []*Tenant{acme, globex} {
+		session, err := tenant.GetOrCreateSessionWithOptions("shared", CreateOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if _, _, err := session.ExecuteCode(context.Background(), "name = '"+tenant.Name()+"'"); err != nil {
+			t.Fatalf("Failed to execute code: %v", err)
+		}
+	}
+
+	session, err := acme.GetSession("shared")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	stdout, _, err := session.ExecuteCode(context.Background(), "print(name)")
+	if err != nil || strings.TrimSpace(stdout) != "acme" {
+		t.Fatalf("Expected acme, got %q (%v)", stdout, err)
+	}
+	if want := filepath.Join(manager.baseDir, tenantsDirName, "acme", "shared"); session.sessionDir != want {
+		t.Fatalf("Expected session directory %s, got %s", want, session.sessionDir)
+	}
+
+	// Neither the default tenant nor another tenant can reach the session
+	if _, err := manager.GetSession("shared"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound from the default tenant, got %v", err)
+	}
+	if _, err := manager.Tenant("initech").GetSession("shared"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound from another tenant, got %v", err)
+	}
+
+	if err := globex.DeleteSession("shared"); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if _, err := acme.GetSession("shared"); err != nil {
+		t.Fatalf("Expected the session of acme to survive, got %v", err)
+	}
+
+	if sessions := acme.ListSessions(); len(sessions) != 1 || sessions[0].Tenant() != "acme" {
+		t.Fatalf("Expected one session of acme, got %d", len(sessions))
+	}
+	if sessions := globex.ListSessions(); len(sessions) != 0 {
+		t.Fatalf("Expected no sessions of globex, got %d", len(sessions))
+	}
+	if sessions := manager.Tenant("").ListSessions(); len(sessions) != 0 {
+		t.Fatalf("Expected no sessions of the default tenant, got %d", len(sessions))
+	}
+}
+
+func TestTenantSessionLimit(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir(), TenantSessionLimit: 2})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	acme := manager.Tenant("acme")
+
+	for i := 0; i < 2; i++ {
+		if _, err := acme.CreateSession(CreateOptions{}); err != nil {
+			t.Fatalf("Failed to create session %d: %v", i+1, err)
+		}
+	}
+	if _, err := acme.CreateSession(CreateOptions{}); !errors.Is(err, ErrTenantSessionLimit) {
+		t.Fatalf("Expected ErrTenantSessionLimit, got %v", err)
+	}
+	if len(acme.ListSessions()) != 2 {
+		t.Fatalf("Expected the refused session not to be kept, got %d sessions", len(acme.ListSessions()))
+	}
+
+	// Other tenants are not affected
+	if _, err := manager.Tenant("globex").CreateSession(CreateOptions{}); err != nil {
+		t.Fatalf("Expected another tenant to create a session, got %v", err)
+	}
+
+	// Deleting a session makes room again
+	if err := acme.DeleteSession(acme.ListSessions()[0].ID); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if _, err := acme.CreateSession(CreateOptions{}); err != nil {
+		t.Fatalf("Expected a session after deleting one, got %v", err)
+	}
+
+	manager.SetTenantSessionLimit(0)
+	if _, err := acme.CreateSession(CreateOptions{}); err != nil {
+		t.Fatalf("Expected no limit, got %v", err)
+	}
+}
+
+func TestTenantDirectories(t *testing.T) {
+	baseDir := t.TempDir()
+	manager, err := NewManagerWithOptions(Options{BaseDir: baseDir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	// Names that are not safe path elements get an escaped directory of
+	// their own
+	for name, dir := range map[string]string{
+		"acme.com":    "acme%2Ecom",
+		"../acme":     "%2E%2E%2Facme",
+		"acme/globex": "acme%2Fglobex",
+		"_tenants":    "_tenants",
+		"acme%2Ecom":  "acme%252Ecom",
+	} {
+		session, err := manager.Tenant(name).CreateSession(CreateOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create session of %q: %v", name, err)
+		}
+		if want := filepath.Join(baseDir, tenantsDirName, dir, session.ID); session.sessionDir != want {
+			t.Fatalf("Expected session directory %s for %q, got %s", want, name, session.sessionDir)
+		}
+		if tenant, ok := tenantFromDir(dir); !ok || tenant != name {
+			t.Fatalf("Expected directory %s to belong to %q, got %q", dir, name, tenant)
+		}
+	}
+
+	// Sessions of escaped tenants are restored into their tenant
+	restarted, err := NewManagerWithOptions(Options{BaseDir: baseDir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if restored, err := restarted.RestoreSessions(time.Hour); err != nil || restored != 5 {
+		t.Fatalf("Expected 5 restored sessions, got %d (%v)", restored, err)
+	}
+	if sessions := restarted.Tenant("acme.com").ListSessions(); len(sessions) != 1 {
+		t.Fatalf("Expected one session of acme.com, got %d", len(sessions))
+	}
+
+	if _, err := manager.Tenant(strings.Repeat(".", 100)).CreateSession(CreateOptions{}); !errors.Is(err, ErrInvalidTenant) {
+		t.Fatalf("Expected ErrInvalidTenant for a name too long to store, got %v", err)
+	}
+}
+
+func TestRestoreTenantSessions(t *testing.T) {
+	baseDir := t.TempDir()
+	manager, err := NewManagerWithOptions(Options{BaseDir: baseDir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	session, err := manager.Tenant("acme").CreateSession(CreateOptions{Owner: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Leave an old directory without metadata behind in the tenant
+	orphanDir := filepath.Join(baseDir, tenantsDirName, "acme", "orphan")
+	if err := os.MkdirAll(orphanDir, 0755); err != nil {
+		t.Fatalf("Failed to create orphan directory: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(orphanDir, old, old)
+
+	restarted, err := NewManagerWithOptions(Options{BaseDir: baseDir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	restored, err := restarted.RestoreSessions(time.Hour)
+	if err != nil || restored != 1 {
+		t.Fatalf("Expected 1 restored session, got %d (%v)", restored, err)
+	}
+
+	restoredSession, err := restarted.Tenant("acme").GetSession(session.ID)
+	if err != nil {
+		t.Fatalf("Expected session to be restored in its tenant, got %v", err)
+	}
+	if restoredSession.Owner() != "alice" {
+		t.Fatalf("Expected owner alice, got %q", restoredSession.Owner())
+	}
+	if _, err := restarted.GetSession(session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected the session to stay out of the default tenant, got %v", err)
+	}
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Fatal("Expected orphaned tenant directory to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, tenantsDirName)); err != nil {
+		t.Fatalf("Expected the tenant directory to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package session
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer returns the tracer for the stages of an execution. It uses the
+// global tracer provider, which does nothing unless the server enables
+// tracing, and is looked up for every use so a provider installed later
+// takes effect.
+func tracer() trace.Tracer {
+	return otel.Tracer("go--python-executor/internal/session")
+}
+
+// endSpan marks span as failed when err is non-nil and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
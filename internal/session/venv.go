@@ -0,0 +1,136 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// venvDirName is the virtual environment inside the harness directory
+const venvDirName = "venv"
+
+var (
+	// ErrInstallDisabled is returned when no wheelhouse is configured
+	ErrInstallDisabled = errors.New("package installation is disabled")
+	// ErrInvalidRequirement is returned for package specifiers that are not
+	// plain requirement names with optional extras and version constraints
+	ErrInvalidRequirement = errors.New("invalid package requirement")
+)
+
+// validRequirement accepts names like numpy, pandas[excel] and
+// requests>=2,<3 but nothing pip would treat as an option, URL or path
+var validRequirement = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(\[[A-Za-z0-9._,-]+\])?([<>=!~]=?[A-Za-z0-9.*+!_-]+(,[<>=!~]=?[A-Za-z0-9.*+!_-]+)*)?$`)
+
+// SetWheelhouse sets the local directory packages are installed from. An
+// empty directory disables installation.
+func (m *Manager) SetWheelhouse(dir string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.wheelhouse = dir
+}
+
+// getWheelhouse returns the configured wheelhouse directory
+func (m *Manager) getWheelhouse() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.wheelhouse
+}
+
+// venvPython is the interpreter of the session's virtual environment
+func (s *Session) venvPython() string {
+	return filepath.Join(s.harnessDir, venvDirName, "bin", "python")
+}
+
+// pythonPath returns the interpreter used for the session: its virtual
+// environment once packages were installed, the configured interpreter
+// otherwise
+func (s *Session) pythonPath() string {
+	if _, err := os.Stat(s.venvPython()); err == nil {
+		return s.venvPython()
+	}
+	return s.manager.python
+}
+
+// HasVenv reports whether the session has its own virtual environment
+func (s *Session) HasVenv() bool {
+	return s.pythonPath() == s.venvPython()
+}
+
+// InstallPackages installs the given requirements from the wheelhouse into
+// the session's virtual environment, creating it first if needed, and
+// returns the resources venv and pip used. Installing takes an interpreter
+// slot like an execution. Output of venv and pip is written to out as it is
+// produced. No network access is used: pip only looks at the wheelhouse.
+func (s *Session) InstallPackages(ctx context.Context, requirements []string, out io.Writer) (Usage, error) {
+	var usage Usage
+	wheelhouse := s.manager.getWheelhouse()
+	if wheelhouse == "" {
+		return usage, ErrInstallDisabled
+	}
+	if len(requirements) == 0 {
+		return usage, fmt.Errorf("%w: no packages given", ErrInvalidRequirement)
+	}
+	for _, requirement := range requirements {
+		if !validRequirement.MatchString(requirement) {
+			return usage, fmt.Errorf("%w: %q", ErrInvalidRequirement, requirement)
+		}
+	}
+
+	if err := s.manager.checkQuota(s); err != nil {
+		return usage, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isRunning {
+		return usage, errors.New("session is no longer running")
+	}
+
+	release, err := s.manager.admit(ctx)
+	if err != nil {
+		return usage, err
+	}
+	defer release()
+	defer s.measureDisk()
+
+	if !s.HasVenv() {
+		fmt.Fprintln(out, "Creating virtual environment")
+		cmd := exec.CommandContext(ctx, s.manager.python, "-m", "venv", filepath.Join(s.harnessDir, venvDirName))
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := runMeasured(cmd, &usage); err != nil {
+			os.RemoveAll(filepath.Join(s.harnessDir, venvDirName))
+			return usage, fmt.Errorf("failed to create virtual environment: %v", err)
+		}
+	}
+
+	args := []string{"-m", "pip", "install",
+		"--no-index", "--find-links", wheelhouse,
+		"--disable-pip-version-check", "--no-input",
+	}
+	cmd := exec.CommandContext(ctx, s.venvPython(), append(args, requirements...)...)
+	cmd.Dir = s.workDir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := runMeasured(cmd, &usage); err != nil {
+		return usage, fmt.Errorf("failed to install packages: %v", err)
+	}
+	return usage, nil
+}
+
+// runMeasured runs cmd and adds the resources it used to usage
+func runMeasured(cmd *exec.Cmd, usage *Usage) error {
+	startedAt := time.Now()
+	err := cmd.Run()
+	used := processUsage(cmd.ProcessState, startedAt)
+	usage.CPUTime += used.CPUTime
+	usage.WallTime += used.WallTime
+	return err
+}
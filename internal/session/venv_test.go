@@ -0,0 +1,133 @@
+package session
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestWheel builds a minimal pure-Python wheel in dir
+func writeTestWheel(t *testing.T, dir string) {
+	file, err := os.Create(filepath.Join(dir, "hello_pkg-1.0-py3-none-any.whl"))
+	if err != nil {
+		t.Fatalf("Failed to create wheel: %v", err)
+	}
+	defer file.Close()
+
+	wheel := zip.NewWriter(file)
+	for name, content := range map[string]string{
+		"hello_pkg/__init__.py":            "GREETING = 'hello from wheel'\n",
+		"hello_pkg-1.0.dist-info/METADATA": "Metadata-Version: 2.1\nName: hello-pkg\nVersion: 1.0\n",
+		"hello_pkg-1.0.dist-info/WHEEL":    "Wheel-Version: 1.0\nGenerator: test\nRoot-Is-Purelib: true\nTag: py3-none-any\n",
+		"hello_pkg-1.0.dist-info/RECORD":   "",
+	} {
+		entry, err := wheel.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to wheel: %v", name, err)
+		}
+		entry.Write([]byte(content))
+	}
+	if err := wheel.Close(); err != nil {
+		t.Fatalf("Failed to write wheel: %v", err)
+	}
+}
+
+func TestInstallPackages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creating a virtual environment is slow")
+	}
+
+	wheelhouse := t.TempDir()
+	writeTestWheel(t, wheelhouse)
+
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir(), Wheelhouse: wheelhouse})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	var output bytes.Buffer
+	usage, err := session.InstallPackages(context.Background(), []string{"hello-pkg==1.0"}, &output)
+	if err != nil {
+		t.Fatalf("Failed to install packages: %v\n%s", err, output.String())
+	}
+	if usage.CPUTime <= 0 || usage.WallTime <= 0 {
+		t.Fatalf("Expected the CPU and wall time of venv and pip to be measured, got %+v", usage)
+	}
+
+	if !strings.Contains(output.String(), "Successfully installed hello-pkg-1.0") {
+		t.Fatalf("Expected pip output to be streamed, got: %s", output.String())
+	}
+
+	if !session.HasVenv() {
+		t.Fatal("Expected session to use its virtual environment")
+	}
+
+	stdout, stderr, err := session.ExecuteCode(context.Background(), "import hello_pkg\nprint(hello_pkg.GREETING)")
+	if err != nil {
+		t.Fatalf("Failed to import installed package: %v (%s)", err, stderr)
+	}
+
+	if stdout != "hello from wheel\n" {
+		t.Fatalf("Expected stdout 'hello from wheel\\n', got '%s'", stdout)
+	}
+
+	// Packages missing from the wheelhouse cannot be fetched from anywhere else
+	output.Reset()
+	if _, err := session.InstallPackages(context.Background(), []string{"requests"}, &output); err == nil {
+		t.Fatal("Expected installing a package missing from the wheelhouse to fail")
+	}
+}
+
+func TestInstallPackagesValidation(t *testing.T) {
+	manager, err := NewManagerWithOptions(Options{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	var output bytes.Buffer
+	if _, err := session.InstallPackages(context.Background(), []string{"numpy"}, &output); !errors.Is(err, ErrInstallDisabled) {
+		t.Fatalf("Expected ErrInstallDisabled without a wheelhouse, got: %v", err)
+	}
+
+	manager.SetWheelhouse(t.TempDir())
+	for _, requirement := range []string{"--index-url=http://example.com", "https://example.com/pkg.whl", "../pkg", "pkg; rm -rf /", ""} {
+		if _, err := session.InstallPackages(context.Background(), []string{requirement}, &output); !errors.Is(err, ErrInvalidRequirement) {
+			t.Fatalf("Expected ErrInvalidRequirement for %q, got: %v", requirement, err)
+		}
+	}
+
+	// Installs wait for an interpreter slot like executions
+	errBusy := errors.New("busy")
+	manager.SetLimiter(refusingLimiter{errBusy})
+	if _, err := session.InstallPackages(context.Background(), []string{"numpy"}, &output); !errors.Is(err, errBusy) {
+		t.Fatalf("Expected the limiter's error, got: %v", err)
+	}
+
+	if session.HasVenv() {
+		t.Fatal("Expected no virtual environment to be created for rejected requests")
+	}
+}
+
+// refusingLimiter admits nothing
+type refusingLimiter struct {
+	err error
+}
+
+func (l refusingLimiter) Acquire(ctx context.Context) (func(), error) {
+	return nil, l.err
+}
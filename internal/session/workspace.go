@@ -0,0 +1,282 @@
+package session
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrInvalidPath is returned for workspace paths that are absolute or leave
+// the workspace
+var ErrInvalidPath = errors.New("invalid workspace path")
+
+// FileInfo describes a file or directory in a session workspace
+type FileInfo struct {
+	Path       string
+	Size       int64
+	IsDir      bool
+	ModifiedAt time.Time
+}
+
+// cleanPath validates a client-supplied workspace path and returns it in
+// the form expected by os.Root
+func cleanPath(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", ErrInvalidPath
+	}
+	name = filepath.Clean(name)
+	if name == "." {
+		return "", ErrInvalidPath
+	}
+	return name, nil
+}
+
+// openWorkspace opens the workspace as a root that confines every access,
+// including symlinks created by user code, to the workspace directory. It
+// returns ErrSessionNotFound once the session has been removed. Callers must
+// hold s.mutex.
+func (s *Session) openWorkspace() (*os.Root, error) {
+	if !s.isRunning {
+		return nil, ErrSessionNotFound
+	}
+	root, err := os.OpenRoot(s.workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace: %v", err)
+	}
+	return root, nil
+}
+
+// WriteFile stores the contents of r at name in the workspace, creating
+// parent directories as needed, and returns the number of bytes written
+func (s *Session) WriteFile(name string, r io.Reader) (int64, error) {
+	name, err := cleanPath(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.manager.checkQuota(s); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.measureDisk()
+
+	root, err := s.openWorkspace()
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+
+	if err := mkdirAll(root, filepath.Dir(name)); err != nil {
+		return 0, err
+	}
+
+	file, err := root.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", name, err)
+	}
+
+	n, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		root.Remove(name)
+		return 0, fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// mkdirAll creates dir and its parents inside root
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if err := mkdirAll(root, filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	return nil
+}
+
+// ReadFile copies the file at name in the workspace to w
+func (s *Session) ReadFile(name string, w io.Writer) error {
+	name, err := cleanPath(name)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	root, err := s.openWorkspace()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrInvalidPath
+	}
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// ListFiles returns every file and directory in the workspace
+func (s *Session) ListFiles() ([]FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	root, err := s.openWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	files := []FileInfo{}
+	err = fs.WalkDir(root.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := FileInfo{Path: name, IsDir: entry.IsDir(), ModifiedAt: info.ModTime()}
+		if !entry.IsDir() {
+			file.Size = info.Size()
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace: %v", err)
+	}
+	return files, nil
+}
+
+// WriteArchive writes a zip archive of the workspace to w
+func (s *Session) WriteArchive(w io.Writer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	root, err := s.openWorkspace()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	archive := zip.NewWriter(w)
+	workspace := root.FS()
+	err = fs.WalkDir(workspace, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if entry.IsDir() {
+			_, err := archive.Create(name + "/")
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+
+		dst, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := workspace.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive workspace: %v", err)
+	}
+	return archive.Close()
+}
+
+// RemoveFile deletes the file or directory tree at name in the workspace
+func (s *Session) RemoveFile(name string) error {
+	name, err := cleanPath(name)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.measureDisk()
+
+	root, err := s.openWorkspace()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	info, err := root.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return root.Remove(name)
+	}
+
+	// Remove directory contents deepest first
+	var names []string
+	err = fs.WalkDir(root.FS(), filepath.ToSlash(name), func(name string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := root.Remove(filepath.FromSlash(names[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceFiles(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Cleanup()
+
+	if _, err := session.WriteFile("data/input.csv", strings.NewReader("a,b\n1,2\n")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var content bytes.Buffer
+	if err := session.ReadFile("data/input.csv", &content); err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if content.String() != "a,b\n1,2\n" {
+		t.Fatalf("Unexpected file content: %q", content.String())
+	}
+
+	files, err := session.ListFiles()
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 2 || files[0].Path != "data" || !files[0].IsDir || files[1].Path != "data/input.csv" || files[1].Size != 8 {
+		t.Fatalf("Unexpected file listing: %+v", files)
+	}
+
+	var archive bytes.Buffer
+	if err := session.WriteArchive(&archive); err != nil {
+		t.Fatalf("Failed to archive workspace: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if len(reader.File) != 2 || reader.File[1].Name != "data/input.csv" {
+		t.Fatalf("Unexpected archive contents: %v", reader.File)
+	}
+
+	if err := session.RemoveFile("data"); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if files, _ := session.ListFiles(); len(files) != 0 {
+		t.Fatalf("Expected empty workspace, got %+v", files)
+	}
+	// A removed session reports so without writing anything
+	session.Cleanup()
+	archive.Reset()
+	if err := session.WriteArchive(&archive); err != ErrSessionNotFound || archive.Len() != 0 {
+		t.Fatalf("Expected ErrSessionNotFound and no output, got %v and %d bytes", err, archive.Len())
+	}
+}
+
+func TestWorkspacePathConfinement(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Cleanup()
+
+	for _, name := range []string{"../escape.txt", "../.harness/session_state.py", "/etc/passwd", "a/../../b", "", "."} {
+		if _, err := session.WriteFile(name, strings.NewReader("x")); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("Expected ErrInvalidPath for %q, got: %v", name, err)
+		}
+	}
+
+	// Symlinks created by user code must not lead outside the workspace
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(outside, []byte("secret"), 0644)
+	code := "import os\nos.symlink(" + `"` + outside + `"` + ", 'link.txt')"
+	if _, stderr, err := session.ExecuteCode(context.Background(), code); err != nil {
+		t.Fatalf("Failed to create symlink: %v (%s)", err, stderr)
+	}
+
+	var content bytes.Buffer
+	if err := session.ReadFile("link.txt", &content); err == nil {
+		t.Fatalf("Expected reading through an escaping symlink to fail, got %q", content.String())
+	}
+
+	if err := session.ReadFile("missing.txt", &content); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected not exist error, got: %v", err)
+	}
+}
+
+func TestWorkspaceLayout(t *testing.T) {
+	manager := NewManager()
+	session, err := manager.GetOrCreateSession("")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Cleanup()
+
+	code := "import os, sys\nopen('out.txt', 'w').write('hi')\nprint(os.getcwd())\nprint(__file__)\nprint(sys.argv)"
+	stdout, stderr, err := session.ExecuteCode(context.Background(), code)
+	if err != nil {
+		t.Fatalf("Failed to execute code: %v (%s)", err, stderr)
+	}
+
+	expected := session.workDir + "\n" + filepath.Join(session.workDir, "main.py") + "\n['main.py']\n"
+	if stdout != expected {
+		t.Fatalf("Expected stdout %q, got %q", expected, stdout)
+	}
+
+	// Relative writes land in the workspace and harness files stay out of it
+	files, err := session.ListFiles()
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "out.txt" {
+		t.Fatalf("Expected only out.txt in the workspace, got %+v", files)
+	}
+
+	// Tracebacks refer to main.py rather than the wrapper script
+	_, stderr, _ = session.ExecuteCode(context.Background(), "x = 1\nraise ValueError('boom')")
+	if !strings.Contains(stderr, "main.py\", line 2") || strings.Contains(stderr, "exec_") {
+		t.Fatalf("Expected traceback pointing at main.py line 2, got %q", stderr)
+	}
+}